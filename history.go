@@ -0,0 +1,60 @@
+package tokens
+
+import (
+	"sort"
+	"time"
+)
+
+// TokenEventType is something that can happen to a RefreshToken.
+type TokenEventType string
+
+const (
+	// TokenEventCreated is when a RefreshToken was created.
+	TokenEventCreated TokenEventType = "created"
+
+	// TokenEventUsed is when a RefreshToken was used.
+	TokenEventUsed TokenEventType = "used"
+
+	// TokenEventRevoked is when a RefreshToken was revoked.
+	TokenEventRevoked TokenEventType = "revoked"
+)
+
+// TokenEvent is something that happened to a RefreshToken, and when. At is
+// the zero value when the Storer doesn't know when it happened, like for
+// RefreshTokens that were used or revoked before the Storer began
+// recording it.
+type TokenEvent struct {
+	Type TokenEventType
+	At   time.Time
+}
+
+// TokenHistory is a RefreshToken and the events that have happened to it,
+// in the order they happened, for building forensic timelines.
+type TokenHistory struct {
+	Token  RefreshToken
+	Events []TokenEvent
+}
+
+// NewTokenHistory returns the TokenHistory of `token`, which was used at
+// `usedAt` and revoked at `revokedAt`. `usedAt` and `revokedAt` are
+// ignored unless `token` is used or revoked, and should be the zero value
+// if it isn't known when that happened. The RefreshToken's creation is
+// always the first event, followed by the events with known times, oldest
+// first, then the events with unknown times.
+func NewTokenHistory(token RefreshToken, usedAt, revokedAt time.Time) TokenHistory {
+	events := []TokenEvent{{Type: TokenEventCreated, At: token.CreatedAt}}
+	if token.Used {
+		events = append(events, TokenEvent{Type: TokenEventUsed, At: usedAt})
+	}
+	if token.Revoked {
+		events = append(events, TokenEvent{Type: TokenEventRevoked, At: revokedAt})
+	}
+	later := events[1:]
+	sort.SliceStable(later, func(i, j int) bool {
+		if later[i].At.IsZero() || later[j].At.IsZero() {
+			return !later[i].At.IsZero() && later[j].At.IsZero()
+		}
+		return later[i].At.Before(later[j].At)
+	})
+	return TokenHistory{Token: token, Events: events}
+}
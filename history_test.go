@@ -0,0 +1,65 @@
+package tokens_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/tokens"
+)
+
+func TestNewTokenHistory(t *testing.T) {
+	t.Parallel()
+
+	created := time.Now().Add(-1 * time.Hour)
+	first, second := created.Add(time.Minute), created.Add(2*time.Minute)
+
+	type testCase struct {
+		used, revoked     bool
+		usedAt, revokedAt time.Time
+		expected          []tokens.TokenEvent
+	}
+	tests := map[string]testCase{
+		"live": {
+			usedAt:   first,
+			expected: []tokens.TokenEvent{{Type: tokens.TokenEventCreated, At: created}},
+		},
+		"used-then-revoked": {
+			used: true, revoked: true, usedAt: first, revokedAt: second,
+			expected: []tokens.TokenEvent{
+				{Type: tokens.TokenEventCreated, At: created},
+				{Type: tokens.TokenEventUsed, At: first},
+				{Type: tokens.TokenEventRevoked, At: second},
+			},
+		},
+		"revoked-then-used": {
+			used: true, revoked: true, usedAt: second, revokedAt: first,
+			expected: []tokens.TokenEvent{
+				{Type: tokens.TokenEventCreated, At: created},
+				{Type: tokens.TokenEventRevoked, At: first},
+				{Type: tokens.TokenEventUsed, At: second},
+			},
+		},
+		"unknown-times-last": {
+			used: true, revoked: true, revokedAt: first,
+			expected: []tokens.TokenEvent{
+				{Type: tokens.TokenEventCreated, At: created},
+				{Type: tokens.TokenEventRevoked, At: first},
+				{Type: tokens.TokenEventUsed},
+			},
+		},
+	}
+	for name, test := range tests {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			token := tokens.RefreshToken{ID: "token", CreatedAt: created, Used: test.used, Revoked: test.revoked}
+			history := tokens.NewTokenHistory(token, test.usedAt, test.revokedAt)
+			if diff := cmp.Diff(tokens.TokenHistory{Token: token, Events: test.expected}, history); diff != "" {
+				t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+)
+
+// KeySet is a source of the public keys used to verify the signatures of
+// JWTs, identified by the JWT's "kid" header. It allows keys to be rotated,
+// or fetched from somewhere like a remote JWKS endpoint.
+type KeySet interface {
+	// KeyForID returns the public key identified by `kid`, or an error
+	// wrapping ErrUnknownSigningKey if there is none.
+	KeyForID(kid string) (crypto.PublicKey, error)
+}
+
+// PublicKeys is a KeySet holding a fixed set of RSA public keys, each
+// identified by its fingerprint.
+type PublicKeys map[string]crypto.PublicKey
+
+// NewPublicKeys returns a PublicKeys containing all of `keys`.
+func NewPublicKeys(keys ...*rsa.PublicKey) (PublicKeys, error) {
+	res := make(PublicKeys, len(keys))
+	for _, key := range keys {
+		fp, err := getPublicKeyFingerprint(key)
+		if err != nil {
+			return nil, err
+		}
+		res[fp] = key
+	}
+	return res, nil
+}
+
+// KeyForID returns the key in `p` whose fingerprint is `kid`.
+func (p PublicKeys) KeyForID(kid string) (crypto.PublicKey, error) {
+	key, ok := p[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownSigningKey, kid)
+	}
+	return key, nil
+}
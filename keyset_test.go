@@ -0,0 +1,234 @@
+package tokens_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/tokens"
+)
+
+func TestValidateWithKeySet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	first := newDependencies(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gomnd // key size is arbitrary, not magic
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	second := first
+	second.JWTPrivateKey = key
+	second.JWTPublicKey = &key.PublicKey
+
+	unknown := newDependencies(t)
+	unknown.Storer = first.Storer
+
+	keys, err := tokens.NewPublicKeys(first.JWTPublicKey, second.JWTPublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error creating key set: %+v\n", err)
+	}
+	validator := first
+	validator.JWTPublicKey = nil
+	validator.KeySet = keys
+
+	signed := map[string]string{}
+	for name, deps := range map[string]tokens.Dependencies{"first": first, "second": second, "unknown": unknown} {
+		token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		signed[name], err = deps.CreateJWT(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+		}
+	}
+
+	for _, name := range []string{"first", "second"} {
+		_, err := validator.Validate(ctx, signed[name])
+		if err != nil {
+			t.Errorf("Unexpected error validating token signed by %s key: %+v\n", name, err)
+		}
+	}
+	_, err = validator.Validate(ctx, signed["unknown"])
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken for token signed by unknown key, got %+v\n", err)
+	}
+}
+
+func TestReSignToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	old := newDependencies(t)
+
+	token, err := old.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	original, err := old.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gomnd // key size is arbitrary, not magic
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	keys, err := tokens.NewPublicKeys(old.JWTPublicKey, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error creating key set: %+v\n", err)
+	}
+	rotated := old
+	rotated.JWTPrivateKey = key
+	rotated.JWTPublicKey = &key.PublicKey
+	rotated.KeySet = keys
+
+	resigned, err := rotated.ReSignToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error re-signing token: %+v\n", err)
+	}
+	result, err := rotated.Validate(ctx, resigned)
+	if err != nil {
+		t.Fatalf("Unexpected error validating re-signed token: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	originalClaims, originalHeader := parseUnverified(t, original)
+	resignedClaims, resignedHeader := parseUnverified(t, resigned)
+	if diff := cmp.Diff(originalClaims, resignedClaims); diff != "" {
+		t.Errorf("Unexpected diff in claims (-wanted, +got): %s", diff)
+	}
+	if originalHeader["kid"] == resignedHeader["kid"] {
+		t.Errorf("Expected re-signed token to have a new kid, both were %v", resignedHeader["kid"])
+	}
+
+	// only the re-signed token validates once the old key is retired from the set
+	newKeys, err := tokens.NewPublicKeys(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error creating key set: %+v\n", err)
+	}
+	retired := rotated
+	retired.KeySet = newKeys
+	_, err = retired.Validate(ctx, resigned)
+	if err != nil {
+		t.Errorf("Unexpected error validating re-signed token after retiring old key: %+v\n", err)
+	}
+	_, err = retired.Validate(ctx, original)
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken validating token signed by retired key, got %+v\n", err)
+	}
+
+	// tokens can still be re-signed once their old key is retired
+	resigned, err = retired.ReSignToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error re-signing token after retiring old key: %+v\n", err)
+	}
+	_, err = retired.Validate(ctx, resigned)
+	if err != nil {
+		t.Errorf("Unexpected error validating token re-signed after retiring old key: %+v\n", err)
+	}
+
+	// but not once they've been revoked, even with a stale copy
+	_, err = retired.Storer.RevokeTokens(ctx, []string{token.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error revoking token: %+v\n", err)
+	}
+	_, err = retired.ReSignToken(ctx, token)
+	if !errors.Is(err, tokens.ErrTokenRevoked) {
+		t.Errorf("Expected tokens.ErrTokenRevoked re-signing revoked token, got %+v\n", err)
+	}
+}
+
+func parseUnverified(t *testing.T, jwtVal string) (jwt.MapClaims, map[string]interface{}) {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	tok, _, err := jwt.NewParser().ParseUnverified(jwtVal, claims)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing JWT: %+v\n", err)
+	}
+	return claims, tok.Header
+}
+
+func TestOnKeyValidated(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	first := newDependencies(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gomnd // key size is arbitrary, not magic
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	second := first
+	second.JWTPrivateKey = key
+	second.JWTPublicKey = &key.PublicKey
+
+	keys, err := tokens.NewPublicKeys(first.JWTPublicKey, second.JWTPublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error creating key set: %+v\n", err)
+	}
+	counts := map[string]int{}
+	validator := first
+	validator.KeySet = keys
+	validator.OnKeyValidated = func(_ context.Context, kid string) {
+		counts[kid]++
+	}
+
+	expected := map[string]int{}
+	for i, deps := range []tokens.Dependencies{first, second, second} {
+		token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		signed, err := deps.CreateJWT(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+		}
+		_, err = validator.Validate(ctx, signed)
+		if err != nil {
+			t.Fatalf("Unexpected error validating token %d: %+v\n", i, err)
+		}
+		signer, err := tokens.NewRSASigner(deps.JWTPrivateKey)
+		if err != nil {
+			t.Fatalf("Unexpected error creating signer: %+v\n", err)
+		}
+		expected[signer.KeyID()]++
+
+		// tokens that fail validation aren't counted
+		_, err = deps.Storer.RevokeTokens(ctx, []string{token.ID})
+		if err != nil {
+			t.Fatalf("Unexpected error revoking token: %+v\n", err)
+		}
+		_, err = validator.Validate(ctx, signed)
+		if !errors.Is(err, tokens.ErrTokenRevoked) {
+			t.Fatalf("Expected tokens.ErrTokenRevoked validating revoked token %d, got %+v\n", i, err)
+		}
+	}
+	if diff := cmp.Diff(expected, counts); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+}
@@ -0,0 +1,58 @@
+package tokens
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RevocationList is a source of revoked RefreshToken IDs that can be
+// checked without a round trip to the Storer, like a list that's
+// periodically refreshed from it and kept in memory, for validating
+// tokens on nodes that can't always reach the Storer.
+type RevocationList interface {
+	// Revoked returns true if the RefreshToken identified by `id` is
+	// revoked. If the RevocationList can't tell, it returns an error,
+	// like ErrRevocationListUnavailable.
+	Revoked(ctx context.Context, id string) (bool, error)
+}
+
+// RevokedIDs is a RevocationList held in memory. It's unavailable until
+// it's first loaded with Set, and callers are responsible for calling Set
+// periodically to keep it up to date. The zero value is ready to be used.
+type RevokedIDs struct {
+	// MaxAge is how long after the last call to Set the RevokedIDs is
+	// still used. Once it's older, Revoked returns
+	// ErrRevocationListUnavailable until Set is called again. If 0, the
+	// RevokedIDs never goes out of date.
+	MaxAge time.Duration
+
+	lock   sync.RWMutex
+	ids    map[string]struct{}
+	loaded time.Time
+}
+
+// Set replaces the revoked IDs in `r` with `ids`.
+func (r *RevokedIDs) Set(ids []string) {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ids = set
+	r.loaded = time.Now()
+}
+
+// Revoked returns true if `id` was in the IDs last passed to Set. If Set
+// has never been called, or was last called more than MaxAge ago,
+// ErrRevocationListUnavailable is returned.
+func (r *RevokedIDs) Revoked(_ context.Context, id string) (bool, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.ids == nil || (r.MaxAge > 0 && time.Since(r.loaded) > r.MaxAge) {
+		return false, ErrRevocationListUnavailable
+	}
+	_, ok := r.ids[id]
+	return ok, nil
+}
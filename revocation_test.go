@@ -0,0 +1,146 @@
+package tokens_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lockbox.dev/tokens"
+)
+
+// countingStorer is a tokens.Storer that counts how many times GetToken
+// is called on it.
+type countingStorer struct {
+	tokens.Storer
+	calls int
+}
+
+func (c *countingStorer) GetToken(ctx context.Context, id string) (tokens.RefreshToken, error) {
+	c.calls++
+	return c.Storer.GetToken(ctx, id)
+}
+
+func TestValidateRevocationList(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	storer := &countingStorer{Storer: deps.Storer}
+	deps.Storer = storer
+	list := &tokens.RevokedIDs{}
+	deps.RevocationList = list
+
+	jwts := map[string]string{}
+	ids := map[string]string{}
+	for _, name := range []string{"revoked", "live"} {
+		token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		jwts[name], err = deps.CreateJWT(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+		}
+		ids[name] = token.ID
+	}
+
+	// before the list is loaded, the storer is consulted
+	_, err := deps.Validate(ctx, jwts["revoked"])
+	if err != nil {
+		t.Errorf("Unexpected error validating token before revocation list loaded: %+v\n", err)
+	}
+	if storer.calls != 1 {
+		t.Errorf("Expected %d storer call, got %d", 1, storer.calls)
+	}
+
+	list.Set([]string{ids["revoked"]})
+	_, err = deps.Validate(ctx, jwts["revoked"])
+	if !errors.Is(err, tokens.ErrTokenRevoked) {
+		t.Errorf("Expected tokens.ErrTokenRevoked validating token on revocation list, got %+v\n", err)
+	}
+	if storer.calls != 1 {
+		t.Errorf("Expected revoked token to be rejected without calling the storer, got %d calls", storer.calls)
+	}
+
+	_, err = deps.Validate(ctx, jwts["live"])
+	if err != nil {
+		t.Errorf("Unexpected error validating token not on revocation list: %+v\n", err)
+	}
+	if storer.calls != 1 {
+		t.Errorf("Expected live token to be accepted without calling the storer, got %d calls", storer.calls)
+	}
+
+	// once the list is stale, the storer is consulted again
+	list.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	_, err = deps.Validate(ctx, jwts["revoked"])
+	if err != nil {
+		t.Errorf("Unexpected error validating token with stale revocation list: %+v\n", err)
+	}
+	if storer.calls != 2 {
+		t.Errorf("Expected %d storer calls, got %d", 2, storer.calls)
+	}
+}
+
+var errStorerUnreachable = errors.New("storer unreachable")
+
+// unreachableStorer is a tokens.Storer that can't be reached. GetToken
+// always fails, and every other method panics.
+type unreachableStorer struct {
+	tokens.Storer
+}
+
+func (unreachableStorer) GetToken(_ context.Context, _ string) (tokens.RefreshToken, error) {
+	return tokens.RefreshToken{}, errStorerUnreachable
+}
+
+func TestValidateRevocationListWithoutStorer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+
+	list := &tokens.RevokedIDs{}
+	deps.RevocationList = list
+	deps.Storer = unreachableStorer{}
+
+	// without a loaded list, the storer has to be consulted
+	_, err = deps.Validate(ctx, jwtVal)
+	if !errors.Is(err, errStorerUnreachable) {
+		t.Errorf("Expected errStorerUnreachable before revocation list loaded, got %+v\n", err)
+	}
+
+	list.Set(nil)
+	got, err := deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Fatalf("Unexpected error validating with fresh revocation list: %+v\n", err)
+	}
+	if got.ID != token.ID || got.ProfileID != token.ProfileID || got.ClientID != token.ClientID {
+		t.Errorf("Expected token from claims to match %+v, got %+v", token, got)
+	}
+
+	list.Set([]string{token.ID})
+	_, err = deps.Validate(ctx, jwtVal)
+	if !errors.Is(err, tokens.ErrTokenRevoked) {
+		t.Errorf("Expected tokens.ErrTokenRevoked with token on revocation list, got %+v\n", err)
+	}
+}
@@ -0,0 +1,175 @@
+package tokens
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Signer produces the signatures for the JWTs created by CreateJWT. Using
+// a Signer, instead of a private key, lets the signing be done by a KMS or
+// HSM, so the private key never needs to be in memory.
+type Signer interface {
+	// Sign returns the raw, unencoded signature of `data`, using the
+	// algorithm returned by Algorithm.
+	Sign(ctx context.Context, data []byte) ([]byte, error)
+
+	// Algorithm returns the JWT "alg" header value for the signatures
+	// Sign produces, like "RS256".
+	Algorithm() string
+
+	// KeyID returns the JWT "kid" header value identifying the key Sign
+	// uses.
+	KeyID() string
+}
+
+// RSASigner is a Signer that signs JWTs using RS256 and an in-memory RSA
+// private key.
+type RSASigner struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// NewRSASigner returns an RSASigner that signs with `key`, identified by the
+// fingerprint of its public key. Keys smaller than DefaultMinRSAKeyBits are
+// rejected with an error wrapping ErrRSAKeyTooSmall.
+func NewRSASigner(key *rsa.PrivateKey) (RSASigner, error) {
+	if key.N.BitLen() < DefaultMinRSAKeyBits {
+		return RSASigner{}, fmt.Errorf("%w: key is %d bits, minimum is %d", ErrRSAKeyTooSmall, key.N.BitLen(), DefaultMinRSAKeyBits)
+	}
+	kid, err := getPublicKeyFingerprint(&key.PublicKey)
+	if err != nil {
+		return RSASigner{}, err
+	}
+	return RSASigner{key: key, kid: kid}, nil
+}
+
+// Sign returns the RS256 signature of `data`.
+func (r RSASigner) Sign(_ context.Context, data []byte) ([]byte, error) {
+	hashed := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, r.key, crypto.SHA256, hashed[:])
+}
+
+// Algorithm returns "RS256".
+func (RSASigner) Algorithm() string {
+	return "RS256"
+}
+
+// KeyID returns the fingerprint of the RSASigner's public key.
+func (r RSASigner) KeyID() string {
+	return r.kid
+}
+
+// ECDSASigner is a Signer that signs JWTs using ES256, ES384, or ES512,
+// depending on the curve of its in-memory ECDSA private key.
+type ECDSASigner struct {
+	key  *ecdsa.PrivateKey
+	kid  string
+	alg  string
+	hash crypto.Hash
+}
+
+// NewECDSASigner returns an ECDSASigner that signs with `key`, identified by
+// the fingerprint of its public key. Only keys on the P-256, P-384, and
+// P-521 curves are supported.
+func NewECDSASigner(key *ecdsa.PrivateKey) (ECDSASigner, error) {
+	signer := ECDSASigner{key: key}
+	switch key.Curve.Params().BitSize {
+	case 256: //nolint:gomnd // curve sizes are defined by the JWA spec, not magic
+		signer.alg, signer.hash = "ES256", crypto.SHA256
+	case 384: //nolint:gomnd // curve sizes are defined by the JWA spec, not magic
+		signer.alg, signer.hash = "ES384", crypto.SHA384
+	case 521: //nolint:gomnd // curve sizes are defined by the JWA spec, not magic
+		signer.alg, signer.hash = "ES512", crypto.SHA512
+	default:
+		return ECDSASigner{}, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, key.Curve.Params().Name)
+	}
+	kid, err := getPublicKeyFingerprint(&key.PublicKey)
+	if err != nil {
+		return ECDSASigner{}, err
+	}
+	signer.kid = kid
+	return signer, nil
+}
+
+// Sign returns the signature of `data`, encoded as the fixed-width
+// concatenation of its R and S values, as JWTs require.
+func (e ECDSASigner) Sign(_ context.Context, data []byte) ([]byte, error) {
+	var hashed []byte
+	switch e.hash {
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		hashed = sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(data)
+		hashed = sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		hashed = sum[:]
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, e.key, hashed)
+	if err != nil {
+		return nil, err
+	}
+	size := (e.key.Curve.Params().BitSize + 7) / 8 //nolint:gomnd // rounding bits up to bytes
+	sig := make([]byte, 2*size)                    //nolint:gomnd // R and S
+	r.FillBytes(sig[:size])
+	s.FillBytes(sig[size:])
+	return sig, nil
+}
+
+// Algorithm returns "ES256", "ES384", or "ES512", depending on the curve
+// of the ECDSASigner's key.
+func (e ECDSASigner) Algorithm() string {
+	return e.alg
+}
+
+// KeyID returns the fingerprint of the ECDSASigner's public key.
+func (e ECDSASigner) KeyID() string {
+	return e.kid
+}
+
+// ParseSignerFromPEM returns a Signer for the first private key in the
+// PEM-encoded `data`, so keys can be used in whichever format they were
+// exported in. PKCS #1 RSA keys ("RSA PRIVATE KEY"), SEC 1 EC keys ("EC
+// PRIVATE KEY"), and PKCS #8 keys ("PRIVATE KEY") holding either are
+// supported. Anything else, including encrypted keys, returns an error
+// wrapping ErrUnsupportedKey. RSA keys smaller than DefaultMinRSAKeyBits
+// return an error wrapping ErrRSAKeyTooSmall, so a misconfigured key is
+// caught when it's loaded.
+func ParseSignerFromPEM(data []byte) (Signer, error) { //nolint:ireturn // returns whichever Signer fits the key
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data found", ErrUnsupportedKey)
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: PEM type %q", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", block.Type, err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return NewRSASigner(k)
+	case *ecdsa.PrivateKey:
+		return NewECDSASigner(k)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
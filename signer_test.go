@@ -0,0 +1,263 @@
+package tokens_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/tokens"
+)
+
+// countingSigner is a tokens.Signer that wraps a tokens.RSASigner and
+// counts how many times it's been asked to sign something.
+type countingSigner struct {
+	tokens.RSASigner
+	calls int
+}
+
+func (c *countingSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	c.calls++
+	return c.RSASigner.Sign(ctx, data)
+}
+
+func TestCreateJWTUsesSigner(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gomnd // key size is arbitrary, not magic
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	rsaSigner, err := tokens.NewRSASigner(key)
+	if err != nil {
+		t.Fatalf("Unexpected error creating RSA signer: %+v\n", err)
+	}
+	signer := &countingSigner{RSASigner: rsaSigner}
+	deps.Signer = signer
+	deps.JWTPrivateKey = nil
+	deps.JWTPublicKey = &key.PublicKey
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	signed, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	if signer.calls != 1 {
+		t.Errorf("Expected signer to be called %d time, was called %d times", 1, signer.calls)
+	}
+
+	result, err := deps.Validate(ctx, signed)
+	if err != nil {
+		t.Fatalf("Unexpected error validating JWT: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+}
+
+func TestParseSignerFromPEM(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gomnd // key size is arbitrary, not magic
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating P-256 key: %+v\n", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating P-384 key: %+v\n", err)
+	}
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating P-521 key: %+v\n", err)
+	}
+
+	type testCase struct {
+		pemType   string
+		der       func() ([]byte, error)
+		publicKey crypto.PublicKey
+		alg       string
+	}
+	tests := map[string]testCase{
+		"pkcs1-rsa": {
+			pemType:   "RSA PRIVATE KEY",
+			der:       func() ([]byte, error) { return x509.MarshalPKCS1PrivateKey(rsaKey), nil },
+			publicKey: &rsaKey.PublicKey,
+			alg:       "RS256",
+		},
+		"pkcs8-rsa": {
+			pemType:   "PRIVATE KEY",
+			der:       func() ([]byte, error) { return x509.MarshalPKCS8PrivateKey(rsaKey) },
+			publicKey: &rsaKey.PublicKey,
+			alg:       "RS256",
+		},
+		"sec1-p256": {
+			pemType:   "EC PRIVATE KEY",
+			der:       func() ([]byte, error) { return x509.MarshalECPrivateKey(p256Key) },
+			publicKey: &p256Key.PublicKey,
+			alg:       "ES256",
+		},
+		"pkcs8-p384": {
+			pemType:   "PRIVATE KEY",
+			der:       func() ([]byte, error) { return x509.MarshalPKCS8PrivateKey(p384Key) },
+			publicKey: &p384Key.PublicKey,
+			alg:       "ES384",
+		},
+		"sec1-p521": {
+			pemType:   "EC PRIVATE KEY",
+			der:       func() ([]byte, error) { return x509.MarshalECPrivateKey(p521Key) },
+			publicKey: &p521Key.PublicKey,
+			alg:       "ES512",
+		},
+	}
+
+	for name, test := range tests {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			der, err := test.der()
+			if err != nil {
+				t.Fatalf("Unexpected error marshaling key: %+v\n", err)
+			}
+			signer, err := tokens.ParseSignerFromPEM(pem.EncodeToMemory(&pem.Block{Type: test.pemType, Bytes: der}))
+			if err != nil {
+				t.Fatalf("Unexpected error parsing key: %+v\n", err)
+			}
+			if signer.Algorithm() != test.alg {
+				t.Errorf("Expected algorithm %q, got %q", test.alg, signer.Algorithm())
+			}
+
+			deps := newDependencies(t)
+			deps.Signer = signer
+			deps.KeySet = tokens.PublicKeys{signer.KeyID(): test.publicKey}
+			deps.SigningAlgorithms = []string{test.alg}
+
+			token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+				CreatedFrom: "test case",
+				ProfileID:   "profile",
+				AccountID:   "account",
+				ClientID:    "client",
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error creating token: %+v\n", err)
+			}
+			signed, err := deps.CreateJWT(ctx, token)
+			if err != nil {
+				t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+			}
+			result, err := deps.Validate(ctx, signed)
+			if err != nil {
+				t.Fatalf("Unexpected error validating JWT: %+v\n", err)
+			}
+			if diff := cmp.Diff(token, result); diff != "" {
+				t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestParseSignerFromPEMUnsupported(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating Ed25519 key: %+v\n", err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling Ed25519 key: %+v\n", err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating P-224 key: %+v\n", err)
+	}
+	p224DER, err := x509.MarshalECPrivateKey(p224Key)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling P-224 key: %+v\n", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gomnd // key size is arbitrary, not magic
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling public key: %+v\n", err)
+	}
+
+	tests := map[string][]byte{
+		"not-pem":    []byte("not a PEM-encoded key"),
+		"public-key": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+		"ed25519":    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}),
+		"p224":       pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: p224DER}),
+	}
+	for name, data := range tests {
+		name, data := name, data
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tokens.ParseSignerFromPEM(data)
+			if !errors.Is(err, tokens.ErrUnsupportedKey) {
+				t.Errorf("Expected tokens.ErrUnsupportedKey, got %+v\n", err)
+			}
+		})
+	}
+}
+
+func TestParseSignerFromPEMUndersizedRSA(t *testing.T) {
+	t.Parallel()
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024) //nolint:gomnd // deliberately undersized
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(small)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling RSA key: %+v\n", err)
+	}
+
+	_, err = tokens.NewRSASigner(small)
+	if !errors.Is(err, tokens.ErrRSAKeyTooSmall) {
+		t.Errorf("Expected tokens.ErrRSAKeyTooSmall creating signer, got %+v\n", err)
+	}
+
+	tests := map[string][]byte{
+		"pkcs1-rsa": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(small)}),
+		"pkcs8-rsa": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}),
+	}
+	for name, data := range tests {
+		name, data := name, data
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tokens.ParseSignerFromPEM(data)
+			if !errors.Is(err, tokens.ErrRSAKeyTooSmall) {
+				t.Errorf("Expected tokens.ErrRSAKeyTooSmall, got %+v\n", err)
+			}
+		})
+	}
+}
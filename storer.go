@@ -8,9 +8,141 @@ import (
 // Storer represents an interface to a persistence method for RefreshTokens. It is used to store, update, and
 // retrieve RefreshTokens.
 type Storer interface {
+	// GetToken returns the RefreshToken specified by `id`, or ErrTokenNotFound if it doesn't
+	// exist.
 	GetToken(ctx context.Context, id string) (RefreshToken, error)
+
+	// GetTokens returns the RefreshTokens specified by `ids`, keyed by their IDs. IDs that don't
+	// exist are left out of the result.
+	GetTokens(ctx context.Context, ids []string) (map[string]RefreshToken, error)
+
+	// CreateToken stores `token`, or returns ErrTokenAlreadyExists if its ID is already in use.
 	CreateToken(ctx context.Context, token RefreshToken) error
-	UpdateTokens(ctx context.Context, change RefreshTokenChange) error
+
+	// CreateOrGetToken stores `token` unless its ID is already in use, returning the stored
+	// RefreshToken and whether it was just created, so retried creations are idempotent.
+	CreateOrGetToken(ctx context.Context, token RefreshToken) (RefreshToken, bool, error)
+
+	// UpdateTokens applies `change` to all the RefreshTokens matching its ID, ProfileID,
+	// ClientID, and AccountID, returning the IDs of those that matched. ErrNoTokenChangeFilter
+	// is returned if none of those are set.
+	UpdateTokens(ctx context.Context, change RefreshTokenChange) ([]string, error)
+
+	// UpdateTokensBatched applies `change` like UpdateTokens, but to at most `batchSize` of the
+	// matching RefreshTokens at a time, committing each batch before starting the next, so
+	// changing a huge number of RefreshTokens doesn't hold them all at once. It returns the
+	// number of RefreshTokens that matched. If `batchSize` is less than 1,
+	// DefaultUpdateBatchSize is used. The change isn't atomic: if an error is returned, the
+	// batches before it have already been applied, and RefreshTokens created while it runs may
+	// be missed.
+	UpdateTokensBatched(ctx context.Context, change RefreshTokenChange, batchSize int) (int, error)
+
+	// UseToken marks the RefreshToken specified by `id` as used, returning ErrTokenUsed if it
+	// already was, and ErrTokenNotFound if it doesn't exist.
 	UseToken(ctx context.Context, id string) error
+
+	// UseAndGetToken atomically marks the RefreshToken specified by `id` as used, returning it
+	// as it was before. ErrTokenRevoked or ErrTokenUsed is returned if it has been revoked or
+	// used, and ErrTokenNotFound if it doesn't exist.
+	UseAndGetToken(ctx context.Context, id string) (RefreshToken, error)
+
+	// TouchToken sets the CreatedAt of the RefreshToken specified by `id` to now, extending
+	// the lifetime of the JWTs issued for it from then on, so sessions can slide forward
+	// instead of being rotated. This trades away the upper bound on how long a stolen token
+	// remains useful: a token that keeps being touched never expires, so only revocation
+	// ends it. Revoked or used RefreshTokens can't be touched, and return ErrTokenRevoked or
+	// ErrTokenUsed.
+	TouchToken(ctx context.Context, id string) error
+
+	// RevokeTokens marks the RefreshTokens specified by `ids` as revoked, returning how many
+	// were revoked. RefreshTokens that were already revoked or don't exist aren't counted.
+	RevokeTokens(ctx context.Context, ids []string) (int, error)
+
+	// RevokeTokenFamily marks all the RefreshTokens with a FamilyID of `familyID` as revoked,
+	// returning how many were revoked. RefreshTokens that were already revoked aren't counted,
+	// and an empty `familyID` matches none.
+	RevokeTokenFamily(ctx context.Context, familyID string) (int, error)
+
+	// MarkTokenReuseAttempt records that the RefreshToken specified by `id` was presented
+	// again after being used, returning how many times that has happened, or ErrTokenNotFound
+	// if it doesn't exist.
+	MarkTokenReuseAttempt(ctx context.Context, id string) (int, error)
+
+	// GetReuseAttempts returns how many times the RefreshToken specified by `id` was presented
+	// after being used, or ErrTokenNotFound if it doesn't exist.
+	GetReuseAttempts(ctx context.Context, id string) (int, error)
+
+	// GetTokensByProfileID returns up to NumTokenResults of the RefreshTokens with a ProfileID
+	// of `profileID`, most recent first. Only RefreshTokens created after `since` and before
+	// `before` are returned, when they're set.
 	GetTokensByProfileID(ctx context.Context, profileID string, since, before time.Time) ([]RefreshToken, error)
+
+	// ListTokensByProfileID returns the RefreshTokens GetTokensByProfileID would, ordered and
+	// filtered according to `opts`, along with whether there were more matching RefreshTokens
+	// than NumTokenResults, for paginating through them.
+	ListTokensByProfileID(ctx context.Context, profileID string, since, before time.Time, opts ListOptions) (toks []RefreshToken, hasMore bool, err error)
+
+	// GetTokensByProfileIDs retrieves the RefreshTokens for each of `profileIDs`, filtered and
+	// sorted like GetTokensByProfileID and capped at NumTokenResults per profile, keyed by
+	// ProfileID. Profiles without any matching RefreshTokens are left out of the result.
+	GetTokensByProfileIDs(ctx context.Context, profileIDs []string, since, before time.Time) (map[string][]RefreshToken, error)
+
+	// GetLatestToken returns the most recently created RefreshToken with a ProfileID matching
+	// `profileID` and a ClientID matching `clientID` that hasn't been revoked or used, or
+	// ErrTokenNotFound if there is none.
+	GetLatestToken(ctx context.Context, profileID, clientID string) (RefreshToken, error)
+
+	// TokenStats returns the number of RefreshTokens stored, and how many of those have been
+	// revoked and used.
+	TokenStats(ctx context.Context) (total, revoked, used int, err error)
+
+	// GetTokenHistory returns the RefreshToken specified by `id` along with the events that
+	// have happened to it, or ErrTokenNotFound if it doesn't exist.
+	GetTokenHistory(ctx context.Context, id string) (TokenHistory, error)
+}
+
+// ListOptions controls how Storer.ListTokensByProfileID lists
+// RefreshTokens. The zero value lists them the same way
+// Storer.GetTokensByProfileID does.
+type ListOptions struct {
+	// Ascending lists the oldest RefreshTokens first, instead of the most
+	// recent, for callers paginating forward through time using `since`.
+	Ascending bool
+
+	// IncludeRevoked controls whether revoked RefreshTokens are listed.
+	// If nil, they are.
+	IncludeRevoked *bool
+
+	// IncludeUsed controls whether used RefreshTokens are listed. If nil,
+	// they are.
+	IncludeUsed *bool
+}
+
+// Includes returns true if `token` should be listed according to the
+// IncludeRevoked and IncludeUsed settings of `o`.
+func (o ListOptions) Includes(token RefreshToken) bool {
+	if token.Revoked && o.IncludeRevoked != nil && !*o.IncludeRevoked {
+		return false
+	}
+	if token.Used && o.IncludeUsed != nil && !*o.IncludeUsed {
+		return false
+	}
+	return true
+}
+
+// TxStorer is an optional interface that Storers can implement to let
+// callers group multiple operations into a single atomic unit. Callers
+// that need atomicity should type-assert their Storer to a TxStorer.
+type TxStorer interface {
+	Storer
+
+	// WithTransaction calls `fn` with a Storer whose operations all take
+	// place in a single transaction. If `fn` returns an error, none of
+	// the changes made through that Storer will be persisted, and the
+	// error will be returned. Otherwise, all the changes will be
+	// persisted together. The Storer passed to `fn` must not be used
+	// after `fn` returns. Storers that wrap another Storer return
+	// ErrTransactionsUnsupported, without calling `fn`, if the Storer they
+	// wrap isn't a TxStorer.
+	WithTransaction(ctx context.Context, fn func(tx Storer) error) error
 }
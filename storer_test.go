@@ -20,13 +20,18 @@ import (
 	"yall.in/colour"
 
 	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/deadline"
 	"lockbox.dev/tokens/storers/memory"
+	"lockbox.dev/tokens/storers/multi"
 	"lockbox.dev/tokens/storers/postgres"
+	"lockbox.dev/tokens/storers/slowlog"
 )
 
 const (
 	changeUsed = 1 << iota
 	changeRevoked
+	changeScopes
+	changeCreatedAt
 	changeVariations
 )
 
@@ -58,13 +63,19 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 
 	// set up our test storers
-	factories = append(factories, memory.Factory{})
+	factories = append(factories, memory.Factory{}, multi.Factory{}, deadline.Factory{}, slowlog.Factory{})
 	if os.Getenv(postgres.TestConnStringEnvVar) != "" {
 		storerConn, err := sql.Open("postgres", os.Getenv(postgres.TestConnStringEnvVar))
 		if err != nil {
 			panic(err)
 		}
 		factories = append(factories, postgres.NewFactory(storerConn))
+
+		customConn, err := sql.Open("postgres", os.Getenv(postgres.TestConnStringEnvVar))
+		if err != nil {
+			panic(err)
+		}
+		factories = append(factories, postgres.NewFactory(customConn, postgres.WithTableName("custom_tokens")))
 	}
 
 	// run the tests
@@ -104,14 +115,17 @@ func TestCreateAndGetToken(t *testing.T) {
 		token := tokens.RefreshToken{
 			ID: uuidOrFail(t),
 			// Postgres only stores times to the millisecond, so we have to round it going in
-			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
-			CreatedFrom: fmt.Sprintf("test case for %T", storer),
-			Scopes:      []string{"https://scopes.impractical.co/this/is/a/very/long/scope/that/is/pretty/long/I/hope/the/database/can/store/this/super/long/scope/that/is/probably/unrealistically/long/but/still/it's/good/to/test/things/like/this", "https://scopes.impractical.co/profiles/view:me"},
-			AccountID:   uuidOrFail(t),
-			ProfileID:   uuidOrFail(t),
-			ClientID:    uuidOrFail(t),
-			Revoked:     false,
-			Used:        true,
+			CreatedAt:        time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom:      fmt.Sprintf("test case for %T", storer),
+			CreatedIP:        "2001:db8::1",
+			CreatedUserAgent: "lockbox tokens test suite",
+			FamilyID:         uuidOrFail(t),
+			Scopes:           []string{"https://scopes.impractical.co/this/is/a/very/long/scope/that/is/pretty/long/I/hope/the/database/can/store/this/super/long/scope/that/is/probably/unrealistically/long/but/still/it's/good/to/test/things/like/this", "https://scopes.impractical.co/profiles/view:me"},
+			AccountID:        uuidOrFail(t),
+			ProfileID:        uuidOrFail(t),
+			ClientID:         uuidOrFail(t),
+			Revoked:          false,
+			Used:             true,
 		}
 
 		err := storer.CreateToken(ctx, token)
@@ -158,6 +172,91 @@ func TestCreateTokenErrTokenAlreadyExists(t *testing.T) {
 	})
 }
 
+func TestCreateAndGetTokenWithoutScopes(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		for name, scopes := range map[string][]string{"nil": nil, "empty": {}} {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("%s scopes test case for %T", name, storer),
+				Scopes:      scopes,
+				AccountID:   uuidOrFail(t),
+				ProfileID:   uuidOrFail(t),
+				ClientID:    uuidOrFail(t),
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token with %s scopes: %+v\n", name, err)
+			}
+			result, err := storer.GetToken(ctx, token.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error retrieving token with %s scopes: %+v\n", name, err)
+			}
+			if result.Scopes != nil {
+				t.Errorf("Expected token with %s scopes to come back with nil scopes, got %#v", name, result.Scopes)
+			}
+			token.Scopes = nil
+			if diff := cmp.Diff(token, result); diff != "" {
+				t.Errorf("Unexpected diff for %s scopes (-wanted, +got): %s", name, diff)
+			}
+		}
+	})
+}
+
+func TestCreateOrGetToken(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		token := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("test case for %T", storer),
+			Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+			AccountID:   uuidOrFail(t),
+			ProfileID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+		}
+		token.FamilyID = token.ID
+
+		result, created, err := storer.CreateOrGetToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		if !created {
+			t.Error("Expected token to be created, but it wasn't")
+		}
+		if diff := cmp.Diff(token, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		duplicate := token
+		duplicate.CreatedFrom = "duplicate"
+		duplicate.ProfileID = uuidOrFail(t)
+		result, created, err = storer.CreateOrGetToken(ctx, duplicate)
+		if err != nil {
+			t.Fatalf("Unexpected error creating duplicate token: %+v\n", err)
+		}
+		if created {
+			t.Error("Expected duplicate token not to be created, but it was")
+		}
+		if diff := cmp.Diff(token, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		stored, err := storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if diff := cmp.Diff(token, stored); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+	})
+}
+
 func TestUseTokenErrTokenUsed(t *testing.T) {
 	t.Parallel()
 
@@ -212,6 +311,316 @@ func TestUseTokenErrTokenUsed(t *testing.T) {
 	})
 }
 
+func TestCreateTokenErrTokenAlreadyExistsConcurrent(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		token := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("test case for %T", storer),
+			Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+			AccountID:   uuidOrFail(t),
+			ProfileID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+		}
+
+		var existsErrors int
+		var successes int
+		var tokenCreators sync.WaitGroup
+		errChan := make(chan error)
+		for i := 0; i < 20; i++ {
+			tokenCreators.Add(1)
+			go func(w *sync.WaitGroup, c chan error) {
+				c <- storer.CreateToken(ctx, token)
+				w.Done()
+			}(&tokenCreators, errChan)
+		}
+		go func(w *sync.WaitGroup, c chan error) {
+			w.Wait()
+			close(c)
+		}(&tokenCreators, errChan)
+		for err := range errChan {
+			if errors.Is(err, tokens.ErrTokenAlreadyExists) {
+				existsErrors++
+			} else if err == nil {
+				successes++
+			} else {
+				t.Errorf("Error creating token: %s", err)
+			}
+		}
+		if successes != 1 {
+			t.Errorf("Expected %d successes, got %d", 1, successes)
+		}
+		if existsErrors != 19 {
+			t.Errorf("Expected %d tokens.ErrTokenAlreadyExists errors, got %d", 19, existsErrors)
+		}
+
+		result, err := storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if diff := cmp.Diff(token, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+	})
+}
+
+func TestTokenErrorsCarryID(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		var toks []tokens.RefreshToken
+		for i := 0; i < 2; i++ {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("test case for %T", storer),
+				AccountID:   uuidOrFail(t),
+				ProfileID:   uuidOrFail(t),
+				ClientID:    uuidOrFail(t),
+				Revoked:     i == 0,
+				Used:        i == 1,
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token: %+v\n", err)
+			}
+			toks = append(toks, token)
+		}
+
+		missing := uuidOrFail(t)
+		_, err := storer.GetToken(ctx, missing)
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound, got %+v\n", err)
+		}
+		var notFound tokens.TokenNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Errorf("Expected tokens.TokenNotFoundError, got %T", err)
+		} else if notFound.ID != missing {
+			t.Errorf("Expected error for ID %q, got %q", missing, notFound.ID)
+		}
+
+		err = storer.TouchToken(ctx, toks[0].ID)
+		if !errors.Is(err, tokens.ErrTokenRevoked) {
+			t.Errorf("Expected tokens.ErrTokenRevoked, got %+v\n", err)
+		}
+		var revoked tokens.TokenRevokedError
+		if !errors.As(err, &revoked) {
+			t.Errorf("Expected tokens.TokenRevokedError, got %T", err)
+		} else if revoked.ID != toks[0].ID {
+			t.Errorf("Expected error for ID %q, got %q", toks[0].ID, revoked.ID)
+		}
+
+		err = storer.UseToken(ctx, toks[1].ID)
+		if !errors.Is(err, tokens.ErrTokenUsed) {
+			t.Errorf("Expected tokens.ErrTokenUsed, got %+v\n", err)
+		}
+		var used tokens.TokenUsedError
+		if !errors.As(err, &used) {
+			t.Errorf("Expected tokens.TokenUsedError, got %T", err)
+		} else if used.ID != toks[1].ID {
+			t.Errorf("Expected error for ID %q, got %q", toks[1].ID, used.ID)
+		}
+	})
+}
+
+func TestGetLatestToken(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		profileID, clientID := uuidOrFail(t), uuidOrFail(t)
+		toks := make([]tokens.RefreshToken, 0, 6)
+		for i, ago := range []time.Duration{5 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour, 30 * time.Minute, 10 * time.Minute} {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(-1 * ago).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("test case %d for %T", i, storer),
+				AccountID:   uuidOrFail(t),
+				ProfileID:   profileID,
+				ClientID:    clientID,
+				Revoked:     i == 2,
+				Used:        i == 3,
+			}
+			switch i {
+			case 4:
+				token.ClientID = uuidOrFail(t)
+			case 5:
+				token.ProfileID = uuidOrFail(t)
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token: %+v\n", err)
+			}
+			toks = append(toks, token)
+		}
+
+		result, err := storer.GetLatestToken(ctx, profileID, clientID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving latest token: %+v\n", err)
+		}
+		if diff := cmp.Diff(toks[1], result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		_, err = storer.GetLatestToken(ctx, profileID, uuidOrFail(t))
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound for client without tokens, got %+v\n", err)
+		}
+	})
+}
+
+func TestTouchToken(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		var toks []tokens.RefreshToken
+		for i := 0; i < 3; i++ {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("test case for %T", storer),
+				AccountID:   uuidOrFail(t),
+				ProfileID:   uuidOrFail(t),
+				ClientID:    uuidOrFail(t),
+				Revoked:     i == 1,
+				Used:        i == 2,
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token: %+v\n", err)
+			}
+			toks = append(toks, token)
+		}
+
+		before := time.Now().Add(-1 * time.Second)
+		err := storer.TouchToken(ctx, toks[0].ID)
+		if err != nil {
+			t.Fatalf("Unexpected error touching token: %+v\n", err)
+		}
+		result, err := storer.GetToken(ctx, toks[0].ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if !result.CreatedAt.After(before) {
+			t.Errorf("Expected CreatedAt to move forward past %s, got %s", before, result.CreatedAt)
+		}
+
+		err = storer.TouchToken(ctx, toks[1].ID)
+		if !errors.Is(err, tokens.ErrTokenRevoked) {
+			t.Errorf("Expected tokens.ErrTokenRevoked touching revoked token, got %+v\n", err)
+		}
+		err = storer.TouchToken(ctx, toks[2].ID)
+		if !errors.Is(err, tokens.ErrTokenUsed) {
+			t.Errorf("Expected tokens.ErrTokenUsed touching used token, got %+v\n", err)
+		}
+		for _, token := range toks[1:] {
+			result, err := storer.GetToken(ctx, token.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+			}
+			if !result.CreatedAt.Equal(token.CreatedAt) {
+				t.Errorf("Expected CreatedAt of token %s to be unchanged at %s, got %s", token.ID, token.CreatedAt, result.CreatedAt)
+			}
+		}
+
+		err = storer.TouchToken(ctx, uuidOrFail(t))
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound touching missing token, got %+v\n", err)
+		}
+	})
+}
+
+func TestUseAndGetToken(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		token := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("test case for %T", storer),
+			Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+			AccountID:   uuidOrFail(t),
+			ProfileID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+		}
+		err := storer.CreateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Error creating token in %T: %+v\n", storer, err)
+		}
+
+		var usedErrors int
+		var successes int
+		var tokenUsers sync.WaitGroup
+		type result struct {
+			token tokens.RefreshToken
+			err   error
+		}
+		results := make(chan result)
+		for i := 0; i < 20; i++ {
+			tokenUsers.Add(1)
+			go func() {
+				defer tokenUsers.Done()
+				tok, err := storer.UseAndGetToken(ctx, token.ID)
+				results <- result{token: tok, err: err}
+			}()
+		}
+		go func() {
+			tokenUsers.Wait()
+			close(results)
+		}()
+		for res := range results {
+			switch {
+			case errors.Is(res.err, tokens.ErrTokenUsed):
+				usedErrors++
+			case res.err == nil:
+				successes++
+				if diff := cmp.Diff(token, res.token); diff != "" {
+					t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+				}
+			default:
+				t.Errorf("Error using token: %s", res.err)
+			}
+		}
+		if successes != 1 {
+			t.Errorf("Expected %d successes, got %d", 1, successes)
+		}
+		if usedErrors != 19 {
+			t.Errorf("Expected %d tokens.ErrTokenUsed errors, got %d", 19, usedErrors)
+		}
+
+		stored, err := storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if !stored.Used {
+			t.Error("Expected token to be marked used, but it wasn't")
+		}
+
+		revoked := token
+		revoked.ID = uuidOrFail(t)
+		revoked.Revoked = true
+		err = storer.CreateToken(ctx, revoked)
+		if err != nil {
+			t.Fatalf("Error creating token in %T: %+v\n", storer, err)
+		}
+		_, err = storer.UseAndGetToken(ctx, revoked.ID)
+		if !errors.Is(err, tokens.ErrTokenRevoked) {
+			t.Errorf("Expected tokens.ErrTokenRevoked, got %+v\n", err)
+		}
+
+		_, err = storer.UseAndGetToken(ctx, uuidOrFail(t))
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound, got %+v\n", err)
+		}
+	})
+}
+
 func TestUseTokenErrTokenNotFound(t *testing.T) {
 	t.Parallel()
 
@@ -234,6 +643,244 @@ func TestGetTokenErrTokenNotFound(t *testing.T) {
 	})
 }
 
+func TestGetTokens(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		expected := map[string]tokens.RefreshToken{}
+		for i := 0; i < 3; i++ {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("test case for %T", storer),
+				Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+				AccountID:   uuidOrFail(t),
+				ProfileID:   uuidOrFail(t),
+				ClientID:    uuidOrFail(t),
+				Revoked:     i == 1,
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token: %+v\n", err)
+			}
+			expected[token.ID] = token
+		}
+
+		ids := []string{uuidOrFail(t)}
+		for id := range expected {
+			ids = append(ids, id)
+		}
+		ids = append(ids, uuidOrFail(t))
+
+		result, err := storer.GetTokens(ctx, ids)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving tokens: %+v\n", err)
+		}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		result, err = storer.GetTokens(ctx, []string{uuidOrFail(t)})
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving missing tokens: %+v\n", err)
+		}
+		if len(result) != 0 {
+			t.Errorf("Expected no tokens, got %+v", result)
+		}
+
+		result, err = storer.GetTokens(ctx, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving no tokens: %+v\n", err)
+		}
+		if len(result) != 0 {
+			t.Errorf("Expected no tokens, got %+v", result)
+		}
+	})
+}
+
+func TestRevokeTokens(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		var toks []tokens.RefreshToken
+		for i := 0; i < 4; i++ {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("test case for %T", storer),
+				Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+				AccountID:   uuidOrFail(t),
+				ProfileID:   uuidOrFail(t),
+				ClientID:    uuidOrFail(t),
+				Revoked:     i == 1,
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token: %+v\n", err)
+			}
+			toks = append(toks, token)
+		}
+
+		// revoke the first three, one of which is already revoked, and
+		// one that doesn't exist, leaving the fourth alone
+		revoked, err := storer.RevokeTokens(ctx, []string{toks[0].ID, toks[1].ID, toks[2].ID, uuidOrFail(t)})
+		if err != nil {
+			t.Fatalf("Unexpected error revoking tokens: %+v\n", err)
+		}
+		if revoked != 2 {
+			t.Errorf("Expected %d tokens to be revoked, got %d", 2, revoked)
+		}
+
+		for pos, token := range toks {
+			result, err := storer.GetToken(ctx, token.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error retrieving token %d: %+v\n", pos, err)
+			}
+			expected := token
+			expected.Revoked = pos < 3
+			if diff := cmp.Diff(expected, result); diff != "" {
+				t.Errorf("Unexpected diff for token %d (-wanted, +got): %s", pos, diff)
+			}
+		}
+
+		revoked, err = storer.RevokeTokens(ctx, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error revoking no tokens: %+v\n", err)
+		}
+		if revoked != 0 {
+			t.Errorf("Expected %d tokens to be revoked, got %d", 0, revoked)
+		}
+	})
+}
+
+func TestReuseAttempts(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		token := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("test case for %T", storer),
+			Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+			AccountID:   uuidOrFail(t),
+			ProfileID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+			Used:        true,
+		}
+		err := storer.CreateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+
+		attempts, err := storer.GetReuseAttempts(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving reuse attempts: %+v\n", err)
+		}
+		if attempts != 0 {
+			t.Errorf("Expected %d reuse attempts, got %d", 0, attempts)
+		}
+
+		for i := 1; i <= 3; i++ {
+			attempts, err = storer.MarkTokenReuseAttempt(ctx, token.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error marking reuse attempt: %+v\n", err)
+			}
+			if attempts != i {
+				t.Errorf("Expected %d reuse attempts after marking, got %d", i, attempts)
+			}
+		}
+
+		attempts, err = storer.GetReuseAttempts(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving reuse attempts: %+v\n", err)
+		}
+		if attempts != 3 {
+			t.Errorf("Expected %d reuse attempts, got %d", 3, attempts)
+		}
+
+		// recording reuse attempts doesn't change the token
+		result, err := storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if diff := cmp.Diff(token, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		_, err = storer.MarkTokenReuseAttempt(ctx, uuidOrFail(t))
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound marking missing token, got %+v\n", err)
+		}
+		_, err = storer.GetReuseAttempts(ctx, uuidOrFail(t))
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound for missing token, got %+v\n", err)
+		}
+	})
+}
+
+func TestRevokeTokenFamily(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		familyID := uuidOrFail(t)
+		var toks []tokens.RefreshToken
+		for i := 0; i < 4; i++ {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("test case for %T", storer),
+				Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+				AccountID:   uuidOrFail(t),
+				ProfileID:   uuidOrFail(t),
+				ClientID:    uuidOrFail(t),
+				FamilyID:    familyID,
+				Revoked:     i == 1,
+				Used:        i < 2,
+			}
+			if i == 3 {
+				token.FamilyID = uuidOrFail(t)
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token: %+v\n", err)
+			}
+			toks = append(toks, token)
+		}
+
+		revoked, err := storer.RevokeTokenFamily(ctx, familyID)
+		if err != nil {
+			t.Fatalf("Unexpected error revoking token family: %+v\n", err)
+		}
+		if revoked != 2 {
+			t.Errorf("Expected %d tokens to be revoked, got %d", 2, revoked)
+		}
+
+		for pos, token := range toks {
+			result, err := storer.GetToken(ctx, token.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error retrieving token %d: %+v\n", pos, err)
+			}
+			expected := token
+			expected.Revoked = token.FamilyID == familyID
+			if diff := cmp.Diff(expected, result); diff != "" {
+				t.Errorf("Unexpected diff for token %d (-wanted, +got): %s", pos, diff)
+			}
+		}
+
+		revoked, err = storer.RevokeTokenFamily(ctx, "")
+		if err != nil {
+			t.Fatalf("Unexpected error revoking empty token family: %+v\n", err)
+		}
+		if revoked != 0 {
+			t.Errorf("Expected %d tokens to be revoked, got %d", 0, revoked)
+		}
+	})
+}
+
 func TestCreateAndGetTokensByProfileID(t *testing.T) {
 	t.Parallel()
 
@@ -299,28 +946,168 @@ func TestCreateAndGetTokensByProfileID(t *testing.T) {
 				t.Errorf("Error creating token %+v in %T: %+v\n", token, storer, err)
 			}
 		}
-		for _, token := range dynamicToks {
-			err := storer.CreateToken(ctx, token)
-			if err != nil {
-				t.Errorf("Error creating dynamic token %+v in %T: %+v\n", token, storer, err)
-			}
+		for _, token := range dynamicToks {
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Errorf("Error creating dynamic token %+v in %T: %+v\n", token, storer, err)
+			}
+		}
+
+		type testcase struct {
+			user          string
+			expectations  []tokens.RefreshToken
+			since, before time.Time
+		}
+		testcases := []testcase{
+			{user: user1, expectations: []tokens.RefreshToken{toks[1], toks[0]}},
+			{user: user2, expectations: []tokens.RefreshToken{toks[2]}},
+			{user: uuidOrFail(t), expectations: nil},
+			{user: user1, before: time.Now(), expectations: []tokens.RefreshToken{toks[0]}},
+			{user: user1, since: time.Now(), expectations: []tokens.RefreshToken{toks[1]}},
+			{user: user3, expectations: dynamicToks[:tokens.NumTokenResults]},
+			{user: user3, before: dynamicToks[tokens.NumTokenResults-1].CreatedAt, expectations: dynamicToks[tokens.NumTokenResults : tokens.NumTokenResults*2]},
+			{user: user3, before: dynamicToks[2*tokens.NumTokenResults-1].CreatedAt, expectations: dynamicToks[tokens.NumTokenResults*2 : tokens.NumTokenResults*3]},
+			{user: user3, before: dynamicToks[3*tokens.NumTokenResults-1].CreatedAt, expectations: dynamicToks[tokens.NumTokenResults*3 : tokens.NumTokenResults*4]},
+		}
+
+		for pos, test := range testcases {
+			pos, test := pos, test
+
+			t.Run(fmt.Sprintf("Case=%d", pos), func(t *testing.T) {
+				t.Parallel()
+				results, err := storer.GetTokensByProfileID(ctx, test.user, test.since, test.before)
+				if err != nil {
+					t.Fatalf("Error retrieving tokens from %T: %+v\n", storer, err)
+				}
+
+				if len(test.expectations) != len(results) {
+					t.Logf("%+v\n", test.expectations)
+					t.Fatalf("Expected %d results, got %d: %+v\n", len(test.expectations), len(results), results)
+				}
+
+				if diff := cmp.Diff(test.expectations, results); diff != "" {
+					t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+				}
+			})
+		}
+	})
+}
+
+func TestListTokensByProfileIDIncludeStates(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		profileID := uuidOrFail(t)
+		var toks []tokens.RefreshToken
+		for tokenNum := 0; tokenNum < 8; tokenNum++ {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(time.Duration(-tokenNum) * time.Second).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("include states test case %d for %T", tokenNum, storer),
+				ProfileID:   profileID,
+				ClientID:    uuidOrFail(t),
+				AccountID:   uuidOrFail(t),
+				Revoked:     tokenNum%2 == 1,
+				Used:        tokenNum%4 >= 2,
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token %+v in %T: %+v\n", token, storer, err)
+			}
+			toks = append(toks, token)
+		}
+
+		yes, no := true, false
+		for _, includeRevoked := range []*bool{nil, &yes, &no} {
+			for _, includeUsed := range []*bool{nil, &yes, &no} {
+				opts := tokens.ListOptions{IncludeRevoked: includeRevoked, IncludeUsed: includeUsed}
+				var expected []tokens.RefreshToken
+				for _, token := range toks {
+					if token.Revoked && includeRevoked != nil && !*includeRevoked {
+						continue
+					}
+					if token.Used && includeUsed != nil && !*includeUsed {
+						continue
+					}
+					expected = append(expected, token)
+				}
+				results, _, err := storer.ListTokensByProfileID(ctx, profileID, time.Time{}, time.Time{}, opts)
+				if err != nil {
+					t.Fatalf("Error listing tokens from %T: %+v\n", storer, err)
+				}
+				if diff := cmp.Diff(expected, results); diff != "" {
+					t.Errorf("Unexpected diff with IncludeRevoked=%v, IncludeUsed=%v (-wanted, +got): %s", fmtBoolPtr(includeRevoked), fmtBoolPtr(includeUsed), diff)
+				}
+			}
+		}
+	})
+}
+
+func fmtBoolPtr(b *bool) string {
+	if b == nil {
+		return "nil"
+	}
+	return fmt.Sprint(*b)
+}
+
+func TestGetTokensByProfileIDs(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		capped, few, empty, unrequested := uuidOrFail(t), uuidOrFail(t), uuidOrFail(t), uuidOrFail(t)
+		expected := map[string][]tokens.RefreshToken{}
+		for profileID, numTokens := range map[string]int{capped: tokens.NumTokenResults + 2, few: 3, unrequested: 2} {
+			for tokenNum := 0; tokenNum < numTokens; tokenNum++ {
+				token := tokens.RefreshToken{
+					ID: uuidOrFail(t),
+					// Postgres only stores times to the millisecond, so we have to round it going in
+					CreatedAt:   time.Now().Add(time.Duration(-tokenNum) * time.Second).Round(time.Millisecond),
+					CreatedFrom: fmt.Sprintf("profile IDs test case %d for %T", tokenNum, storer),
+					ProfileID:   profileID,
+					ClientID:    uuidOrFail(t),
+					AccountID:   uuidOrFail(t),
+				}
+				err := storer.CreateToken(ctx, token)
+				if err != nil {
+					t.Fatalf("Error creating token %+v in %T: %+v\n", token, storer, err)
+				}
+				if profileID != unrequested && len(expected[profileID]) < tokens.NumTokenResults {
+					expected[profileID] = append(expected[profileID], token)
+				}
+			}
+		}
+
+		results, err := storer.GetTokensByProfileIDs(ctx, []string{capped, few, empty}, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatalf("Error retrieving tokens from %T: %+v\n", storer, err)
+		}
+		if diff := cmp.Diff(expected, results); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		results, err = storer.GetTokensByProfileIDs(ctx, []string{few}, expected[few][2].CreatedAt, time.Time{})
+		if err != nil {
+			t.Fatalf("Error retrieving tokens since %s from %T: %+v\n", expected[few][2].CreatedAt, storer, err)
+		}
+		if diff := cmp.Diff(map[string][]tokens.RefreshToken{few: expected[few][:2]}, results); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
 		}
+	})
+}
+
+func TestListTokensByProfileIDHasMore(t *testing.T) {
+	t.Parallel()
 
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
 		type testcase struct {
-			user          string
-			expectations  []tokens.RefreshToken
-			since, before time.Time
+			numTokens int
+			hasMore   bool
 		}
 		testcases := []testcase{
-			{user: user1, expectations: []tokens.RefreshToken{toks[1], toks[0]}},
-			{user: user2, expectations: []tokens.RefreshToken{toks[2]}},
-			{user: uuidOrFail(t), expectations: nil},
-			{user: user1, before: time.Now(), expectations: []tokens.RefreshToken{toks[0]}},
-			{user: user1, since: time.Now(), expectations: []tokens.RefreshToken{toks[1]}},
-			{user: user3, expectations: dynamicToks[:tokens.NumTokenResults]},
-			{user: user3, before: dynamicToks[tokens.NumTokenResults-1].CreatedAt, expectations: dynamicToks[tokens.NumTokenResults : tokens.NumTokenResults*2]},
-			{user: user3, before: dynamicToks[2*tokens.NumTokenResults-1].CreatedAt, expectations: dynamicToks[tokens.NumTokenResults*2 : tokens.NumTokenResults*3]},
-			{user: user3, before: dynamicToks[3*tokens.NumTokenResults-1].CreatedAt, expectations: dynamicToks[tokens.NumTokenResults*3 : tokens.NumTokenResults*4]},
+			{numTokens: tokens.NumTokenResults - 1, hasMore: false},
+			{numTokens: tokens.NumTokenResults, hasMore: false},
+			{numTokens: tokens.NumTokenResults + 1, hasMore: true},
 		}
 
 		for pos, test := range testcases {
@@ -328,17 +1115,38 @@ func TestCreateAndGetTokensByProfileID(t *testing.T) {
 
 			t.Run(fmt.Sprintf("Case=%d", pos), func(t *testing.T) {
 				t.Parallel()
-				results, err := storer.GetTokensByProfileID(ctx, test.user, test.since, test.before)
-				if err != nil {
-					t.Fatalf("Error retrieving tokens from %T: %+v\n", storer, err)
-				}
 
-				if len(test.expectations) != len(results) {
-					t.Logf("%+v\n", test.expectations)
-					t.Fatalf("Expected %d results, got %d: %+v\n", len(test.expectations), len(results), results)
+				profileID := uuidOrFail(t)
+				var toks []tokens.RefreshToken
+				for tokenNum := 0; tokenNum < test.numTokens; tokenNum++ {
+					token := tokens.RefreshToken{
+						ID: uuidOrFail(t),
+						// Postgres only stores times to the millisecond, so we have to round it going in
+						CreatedAt:   time.Now().Add(time.Duration(-tokenNum) * time.Second).Round(time.Millisecond),
+						CreatedFrom: fmt.Sprintf("has more test case %d for %T", tokenNum, storer),
+						ProfileID:   profileID,
+						ClientID:    uuidOrFail(t),
+						AccountID:   uuidOrFail(t),
+					}
+					err := storer.CreateToken(ctx, token)
+					if err != nil {
+						t.Fatalf("Error creating token %+v in %T: %+v\n", token, storer, err)
+					}
+					toks = append(toks, token)
 				}
 
-				if diff := cmp.Diff(test.expectations, results); diff != "" {
+				results, hasMore, err := storer.ListTokensByProfileID(ctx, profileID, time.Time{}, time.Time{}, tokens.ListOptions{})
+				if err != nil {
+					t.Fatalf("Error listing tokens from %T: %+v\n", storer, err)
+				}
+				if hasMore != test.hasMore {
+					t.Errorf("Expected hasMore to be %v, got %v", test.hasMore, hasMore)
+				}
+				expected := toks
+				if len(expected) > tokens.NumTokenResults {
+					expected = expected[:tokens.NumTokenResults]
+				}
+				if diff := cmp.Diff(expected, results); diff != "" {
 					t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
 				}
 			})
@@ -346,6 +1154,76 @@ func TestCreateAndGetTokensByProfileID(t *testing.T) {
 	})
 }
 
+func TestListTokensByProfileIDAscending(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		profileID := uuidOrFail(t)
+		var toks []tokens.RefreshToken
+		for tokenNum := 0; tokenNum < tokens.NumTokenResults*2+5; tokenNum++ {
+			token := tokens.RefreshToken{
+				ID: uuidOrFail(t),
+				// Postgres only stores times to the millisecond, so we have to round it going in
+				CreatedAt:   time.Now().Add(time.Duration(-tokenNum) * time.Minute).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("ascending test case %d for %T", tokenNum, storer),
+				ProfileID:   profileID,
+				ClientID:    uuidOrFail(t),
+				AccountID:   uuidOrFail(t),
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token %+v in %T: %+v\n", token, storer, err)
+			}
+			toks = append(toks, token)
+		}
+		// oldest first
+		sort.Slice(toks, func(i, j int) bool { return toks[i].CreatedAt.Before(toks[j].CreatedAt) })
+
+		// paginate forward through every token using since
+		var results []tokens.RefreshToken
+		var since time.Time
+		for page := 0; ; page++ {
+			if page > 3 {
+				t.Fatalf("Expected pagination to finish after 3 pages, still going")
+			}
+			pageToks, hasMore, err := storer.ListTokensByProfileID(ctx, profileID, since, time.Time{}, tokens.ListOptions{Ascending: true})
+			if err != nil {
+				t.Fatalf("Error listing tokens from %T: %+v\n", storer, err)
+			}
+			results = append(results, pageToks...)
+			if !hasMore {
+				break
+			}
+			since = pageToks[len(pageToks)-1].CreatedAt
+		}
+		if diff := cmp.Diff(toks, results); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		// before is still respected, returning the oldest tokens before it
+		before := toks[10].CreatedAt
+		results, hasMore, err := storer.ListTokensByProfileID(ctx, profileID, time.Time{}, before, tokens.ListOptions{Ascending: true})
+		if err != nil {
+			t.Fatalf("Error listing tokens from %T: %+v\n", storer, err)
+		}
+		if hasMore {
+			t.Error("Expected no more tokens before the 11th, but there were")
+		}
+		if diff := cmp.Diff(toks[:10], results); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+
+		// the default order is still most recent first
+		results, _, err = storer.ListTokensByProfileID(ctx, profileID, time.Time{}, before, tokens.ListOptions{})
+		if err != nil {
+			t.Fatalf("Error listing tokens from %T: %+v\n", storer, err)
+		}
+		if len(results) != 10 || !results[0].CreatedAt.Equal(toks[9].CreatedAt) {
+			t.Errorf("Expected the most recent token before the 11th first, got %+v", results)
+		}
+	})
+}
+
 func TestCreateUpdateTokenNoChangeFilter(t *testing.T) {
 	t.Parallel()
 
@@ -374,7 +1252,7 @@ func TestCreateUpdateTokenNoChangeFilter(t *testing.T) {
 			t.Fatalf("Error creating token in %T: %+v\n", storer, err)
 		}
 
-		err = storer.UpdateTokens(ctx, change)
+		_, err = storer.UpdateTokens(ctx, change)
 		if !errors.Is(err, tokens.ErrNoTokenChangeFilter) {
 			t.Errorf("Expected tokens.ErrNoTokenChangeFilter, %T returned %+v\n", storer, err)
 		}
@@ -407,6 +1285,8 @@ func TestCreateAndUpdateTokensByFilters(t *testing.T) {
 						t.Parallel()
 						var change tokens.RefreshTokenChange
 						var revoked, used bool
+						var scopes []string
+						var createdAt time.Time
 						client1 := uuidOrFail(t)
 						client2 := uuidOrFail(t)
 						client3 := uuidOrFail(t)
@@ -455,6 +1335,7 @@ func TestCreateAndUpdateTokensByFilters(t *testing.T) {
 								ClientID:    client,
 								ProfileID:   profile,
 								AccountID:   account,
+								Scopes:      []string{"https://test.lockbox.dev/basic/scope"},
 								Revoked:     tokenNum%2 == 0,
 								Used:        tokenNum%2 != 0,
 							})
@@ -491,11 +1372,25 @@ func TestCreateAndUpdateTokensByFilters(t *testing.T) {
 							used = variation%2 != 0
 							change.Used = &used
 						}
+						if variation&changeScopes != 0 {
+							// every third variation clears the scopes instead of replacing them
+							if variation%3 != 0 {
+								scopes = []string{"https://test.lockbox.dev/updated/scope", fmt.Sprintf("https://test.lockbox.dev/variation/%d", variation)}
+							} else {
+								scopes = []string{}
+							}
+							change.Scopes = &scopes
+						}
+						if variation&changeCreatedAt != 0 {
+							createdAt = time.Now().Add(-1 * time.Duration(variation) * time.Hour).Round(time.Millisecond)
+							change.CreatedAt = &createdAt
+						}
 
-						err := storer.UpdateTokens(ctx, change)
+						ids, err := storer.UpdateTokens(ctx, change)
 						if err != nil {
 							t.Fatalf("Error updating token in %T: %+v\n", storer, err)
 						}
+						var expectedIDs []string
 						for _, tok := range toks {
 							expectation := tok
 							if (change.ID == "" || tok.ID == change.ID) &&
@@ -503,6 +1398,9 @@ func TestCreateAndUpdateTokensByFilters(t *testing.T) {
 								(change.ClientID == "" || tok.ClientID == change.ClientID) &&
 								(change.AccountID == "" || tok.AccountID == change.AccountID) {
 								expectation = tokens.ApplyChange(expectation, change)
+								if !change.IsEmpty() {
+									expectedIDs = append(expectedIDs, tok.ID)
+								}
 							}
 							result, err := storer.GetToken(ctx, tok.ID)
 							if err != nil {
@@ -512,9 +1410,342 @@ func TestCreateAndUpdateTokensByFilters(t *testing.T) {
 								t.Errorf("Unexpected diff on change %d (ID %s): %s", variation, tok.ID, diff)
 							}
 						}
+						sort.Strings(ids)
+						sort.Strings(expectedIDs)
+						if diff := cmp.Diff(expectedIDs, ids); diff != "" {
+							t.Errorf("Unexpected diff in affected IDs on change %d (-wanted, +got): %s", variation, diff)
+						}
 					})
 				}
 			})
 		}
 	})
 }
+
+func TestWithTransactionRollback(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		txStorer, ok := storer.(tokens.TxStorer)
+		if !ok {
+			t.Skipf("%T doesn't implement tokens.TxStorer", storer)
+		}
+
+		existing := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("test case for %T", storer),
+			ProfileID:   uuidOrFail(t),
+			AccountID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+		}
+		err := storer.CreateToken(ctx, existing)
+		if err != nil {
+			t.Fatalf("Error creating token in %T: %+v\n", storer, err)
+		}
+
+		created := existing
+		created.ID = uuidOrFail(t)
+		errRollback := errors.New("roll it back")
+
+		err = txStorer.WithTransaction(ctx, func(tx tokens.Storer) error {
+			err := tx.CreateToken(ctx, created)
+			if err != nil {
+				return err
+			}
+			revoked := true
+			_, err = tx.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: existing.ID, Revoked: &revoked})
+			if err != nil {
+				return err
+			}
+			err = tx.UseToken(ctx, existing.ID)
+			if err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("Expected transaction error, %T returned %+v\n", storer, err)
+		}
+
+		_, err = storer.GetToken(ctx, created.ID)
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound for rolled back token, %T returned %+v\n", storer, err)
+		}
+		result, err := storer.GetToken(ctx, existing.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if diff := cmp.Diff(existing, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+	})
+}
+
+func TestWithTransactionCommit(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		txStorer, ok := storer.(tokens.TxStorer)
+		if !ok {
+			t.Skipf("%T doesn't implement tokens.TxStorer", storer)
+		}
+
+		token := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("test case for %T", storer),
+			ProfileID:   uuidOrFail(t),
+			AccountID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+		}
+
+		err := txStorer.WithTransaction(ctx, func(tx tokens.Storer) error {
+			err := tx.CreateToken(ctx, token)
+			if err != nil {
+				return err
+			}
+			return tx.UseToken(ctx, token.ID)
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error from transaction in %T: %+v\n", storer, err)
+		}
+
+		expected := token
+		expected.Used = true
+		result, err := storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+	})
+}
+
+func TestTokenStats(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		total, revoked, used, err := storer.TokenStats(ctx)
+		if err != nil {
+			t.Fatalf("Error retrieving token stats from %T: %+v\n", storer, err)
+		}
+		if total != 0 || revoked != 0 || used != 0 {
+			t.Errorf("Expected no tokens in an empty %T, got total=%d revoked=%d used=%d", storer, total, revoked, used)
+		}
+
+		for tokenNum := 0; tokenNum < 30; tokenNum++ {
+			token := tokens.RefreshToken{
+				ID:          uuidOrFail(t),
+				CreatedAt:   time.Now().Add(time.Duration(tokenNum) * time.Second).Round(time.Millisecond),
+				CreatedFrom: fmt.Sprintf("stats test case %d for %T", tokenNum, storer),
+				ProfileID:   uuidOrFail(t),
+				ClientID:    uuidOrFail(t),
+				AccountID:   uuidOrFail(t),
+				Revoked:     tokenNum%3 == 0,
+				Used:        tokenNum%5 == 0,
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("Error creating token %+v in %T: %+v\n", token, storer, err)
+			}
+		}
+
+		total, revoked, used, err = storer.TokenStats(ctx)
+		if err != nil {
+			t.Fatalf("Error retrieving token stats from %T: %+v\n", storer, err)
+		}
+		if total != 30 {
+			t.Errorf("Expected %d tokens, got %d", 30, total)
+		}
+		if revoked != 10 {
+			t.Errorf("Expected %d revoked tokens, got %d", 10, revoked)
+		}
+		if used != 6 {
+			t.Errorf("Expected %d used tokens, got %d", 6, used)
+		}
+	})
+}
+
+func TestGetTokenHistory(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		token := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("history test case for %T", storer),
+			ProfileID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+			AccountID:   uuidOrFail(t),
+		}
+		err := storer.CreateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+		err = storer.UseToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Error using token: %+v\n", err)
+		}
+		_, err = storer.RevokeTokens(ctx, []string{token.ID})
+		if err != nil {
+			t.Fatalf("Error revoking token: %+v\n", err)
+		}
+
+		history, err := storer.GetTokenHistory(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token history: %+v\n", err)
+		}
+		token.Used = true
+		token.Revoked = true
+		if diff := cmp.Diff(token, history.Token); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+		types := make([]tokens.TokenEventType, 0, len(history.Events))
+		for _, event := range history.Events {
+			types = append(types, event.Type)
+		}
+		if diff := cmp.Diff([]tokens.TokenEventType{tokens.TokenEventCreated, tokens.TokenEventUsed, tokens.TokenEventRevoked}, types); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+		if len(history.Events) > 0 && !history.Events[0].At.Equal(token.CreatedAt) {
+			t.Errorf("Expected created event at %s, got %s", token.CreatedAt, history.Events[0].At)
+		}
+		var last time.Time
+		for _, event := range history.Events {
+			if event.At.IsZero() {
+				continue
+			}
+			if event.At.Before(last) {
+				t.Errorf("Expected %s event at %s to not be before %s", event.Type, event.At, last)
+			}
+			last = event.At
+		}
+
+		_, err = storer.GetTokenHistory(ctx, uuidOrFail(t))
+		if !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected tokens.ErrTokenNotFound, got %+v\n", err)
+		}
+	})
+}
+
+func TestUpdateTokensBatched(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		const numTokens = 250
+		createProfile := func(t *testing.T) (string, []tokens.RefreshToken) {
+			t.Helper()
+			profileID := uuidOrFail(t)
+			toks := make([]tokens.RefreshToken, 0, numTokens)
+			for tokenNum := 0; tokenNum < numTokens; tokenNum++ {
+				token := tokens.RefreshToken{
+					ID: uuidOrFail(t),
+					// Postgres only stores times to the millisecond, so we have to round it going in
+					CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+					CreatedFrom: fmt.Sprintf("batched update test case %d for %T", tokenNum, storer),
+					ProfileID:   profileID,
+					ClientID:    uuidOrFail(t),
+					AccountID:   uuidOrFail(t),
+					Revoked:     tokenNum%4 == 0,
+				}
+				err := storer.CreateToken(ctx, token)
+				if err != nil {
+					t.Fatalf("Error creating token %+v in %T: %+v\n", token, storer, err)
+				}
+				toks = append(toks, token)
+			}
+			return profileID, toks
+		}
+
+		revoked := true
+		singleProfile, singleToks := createProfile(t)
+		ids, err := storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ProfileID: singleProfile, Revoked: &revoked})
+		if err != nil {
+			t.Fatalf("Error updating tokens: %+v\n", err)
+		}
+		if len(ids) != numTokens {
+			t.Fatalf("Expected %d tokens to be updated, got %d", numTokens, len(ids))
+		}
+		untouchedProfile, untouchedToks := createProfile(t)
+
+		for _, batchSize := range []int{7, 25, numTokens, numTokens * 2, 0} {
+			batchSize := batchSize
+			t.Run(fmt.Sprintf("batchSize=%d", batchSize), func(t *testing.T) {
+				profileID, toks := createProfile(t)
+				count, err := storer.UpdateTokensBatched(ctx, tokens.RefreshTokenChange{ProfileID: profileID, Revoked: &revoked}, batchSize)
+				if err != nil {
+					t.Fatalf("Error updating tokens in batches of %d: %+v\n", batchSize, err)
+				}
+				if count != len(ids) {
+					t.Errorf("Expected %d tokens to be updated, got %d", len(ids), count)
+				}
+				for pos, token := range toks {
+					single, err := storer.GetToken(ctx, singleToks[pos].ID)
+					if err != nil {
+						t.Fatalf("Error retrieving token %s: %+v\n", singleToks[pos].ID, err)
+					}
+					batched, err := storer.GetToken(ctx, token.ID)
+					if err != nil {
+						t.Fatalf("Error retrieving token %s: %+v\n", token.ID, err)
+					}
+					if single.Revoked != batched.Revoked || single.Used != batched.Used {
+						t.Errorf("Expected token %d to be revoked=%v used=%v, got revoked=%v used=%v", pos, single.Revoked, single.Used, batched.Revoked, batched.Used)
+					}
+				}
+			})
+		}
+
+		untouchedIDs := make([]string, 0, len(untouchedToks))
+		for _, token := range untouchedToks {
+			untouchedIDs = append(untouchedIDs, token.ID)
+		}
+		results, err := storer.GetTokens(ctx, untouchedIDs)
+		if err != nil {
+			t.Fatalf("Error retrieving tokens: %+v\n", err)
+		}
+		for _, token := range untouchedToks {
+			if result := results[token.ID]; result.Revoked != token.Revoked {
+				t.Errorf("Expected token %s of profile %s to be revoked=%v, got %v", token.ID, untouchedProfile, token.Revoked, result.Revoked)
+			}
+		}
+	})
+}
+
+func TestCreateTokenLongestCreatedIP(t *testing.T) {
+	t.Parallel()
+
+	runTest(t, func(t *testing.T, storer tokens.Storer, ctx context.Context) {
+		// the longest textual form of an IP address tokens.ValidateToken
+		// accepts, which every Storer needs to be able to hold
+		token := tokens.RefreshToken{
+			ID: uuidOrFail(t),
+			// Postgres only stores times to the millisecond, so we have to round it going in
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: fmt.Sprintf("created IP test case for %T", storer),
+			CreatedIP:   "ffff:ffff:ffff:ffff:ffff:ffff:255.255.255.255",
+			ProfileID:   uuidOrFail(t),
+			ClientID:    uuidOrFail(t),
+			AccountID:   uuidOrFail(t),
+		}
+		err := tokens.ValidateToken(token)
+		if err != nil {
+			t.Fatalf("Unexpected error validating token: %+v\n", err)
+		}
+		err = storer.CreateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Error creating token with CreatedIP %q in %T: %+v\n", token.CreatedIP, storer, err)
+		}
+		result, err := storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+		}
+		if diff := cmp.Diff(token, result); diff != "" {
+			t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"lockbox.dev/tokens"
+)
+
+var _ tokens.TxStorer = Storer{}
+
+// Storer is an implementation of the Storer interface that wraps another
+// Storer, checking whether the context.Context passed to each method is
+// already done before calling the wrapped Storer. If it is, the
+// context's error is returned without calling the wrapped Storer. This
+// gives Storers that don't honor context cancellation themselves, like
+// the memory Storer, the same semantics as those that do.
+type Storer struct {
+	inner tokens.Storer
+}
+
+// NewStorer returns an instance of Storer that is ready to be used as a
+// Storer, wrapping `inner`.
+func NewStorer(inner tokens.Storer) Storer {
+	return Storer{inner: inner}
+}
+
+// GetToken retrieves the tokens.RefreshToken with an ID matching `token`
+// from the wrapped Storer.
+func (s Storer) GetToken(ctx context.Context, token string) (tokens.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	return s.inner.GetToken(ctx, token)
+}
+
+// GetTokens retrieves the tokens.RefreshTokens with IDs matching `ids`
+// from the wrapped Storer, keyed by their IDs.
+func (s Storer) GetTokens(ctx context.Context, ids []string) (map[string]tokens.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.inner.GetTokens(ctx, ids)
+}
+
+// CreateToken inserts the passed tokens.RefreshToken into the wrapped
+// Storer.
+func (s Storer) CreateToken(ctx context.Context, token tokens.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.inner.CreateToken(ctx, token)
+}
+
+// CreateOrGetToken inserts the passed tokens.RefreshToken into the
+// wrapped Storer unless it already exists, returning the stored
+// tokens.RefreshToken and whether it was just created.
+func (s Storer) CreateOrGetToken(ctx context.Context, token tokens.RefreshToken) (tokens.RefreshToken, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	return s.inner.CreateOrGetToken(ctx, token)
+}
+
+// UpdateTokens applies `change` to all the tokens.RefreshTokens in the
+// wrapped Storer that match the ID, ProfileID, ClientID, or AccountID
+// constraints of `change`, returning the IDs of the tokens.RefreshTokens
+// that matched.
+func (s Storer) UpdateTokens(ctx context.Context, change tokens.RefreshTokenChange) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.inner.UpdateTokens(ctx, change)
+}
+
+// UpdateTokensBatched applies `change` to all the tokens.RefreshTokens in
+// the wrapped Storer that match the ID, ProfileID, ClientID, or AccountID
+// constraints of `change`, `batchSize` at a time, returning how many
+// matched. The deadline is only checked before the first batch; the
+// wrapped Storer is responsible for stopping between batches.
+func (s Storer) UpdateTokensBatched(ctx context.Context, change tokens.RefreshTokenChange, batchSize int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.inner.UpdateTokensBatched(ctx, change, batchSize)
+}
+
+// UseToken marks the tokens.RefreshToken specified by `id` as used in the
+// wrapped Storer.
+func (s Storer) UseToken(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.inner.UseToken(ctx, id)
+}
+
+// UseAndGetToken atomically marks the tokens.RefreshToken specified by
+// `id` as used in the wrapped Storer, returning it as it was before.
+func (s Storer) UseAndGetToken(ctx context.Context, id string) (tokens.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	return s.inner.UseAndGetToken(ctx, id)
+}
+
+// TouchToken sets the CreatedAt of the tokens.RefreshToken specified by
+// `id` to now in the wrapped Storer.
+func (s Storer) TouchToken(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.inner.TouchToken(ctx, id)
+}
+
+// RevokeTokens marks the tokens.RefreshTokens with IDs matching `ids` as
+// revoked in the wrapped Storer.
+func (s Storer) RevokeTokens(ctx context.Context, ids []string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.inner.RevokeTokens(ctx, ids)
+}
+
+// RevokeTokenFamily marks the tokens.RefreshTokens with a FamilyID
+// matching `familyID` as revoked in the wrapped Storer.
+func (s Storer) RevokeTokenFamily(ctx context.Context, familyID string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.inner.RevokeTokenFamily(ctx, familyID)
+}
+
+// MarkTokenReuseAttempt records a reuse attempt for the
+// tokens.RefreshToken specified by `id` in the wrapped Storer.
+func (s Storer) MarkTokenReuseAttempt(ctx context.Context, id string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.inner.MarkTokenReuseAttempt(ctx, id)
+}
+
+// GetReuseAttempts returns the number of reuse attempts recorded for the
+// tokens.RefreshToken specified by `id` in the wrapped Storer.
+func (s Storer) GetReuseAttempts(ctx context.Context, id string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.inner.GetReuseAttempts(ctx, id)
+}
+
+// GetTokensByProfileID retrieves up to NumTokenResults
+// tokens.RefreshTokens with a ProfileID matching `profileID` from the
+// wrapped Storer.
+func (s Storer) GetTokensByProfileID(ctx context.Context, profileID string, since, before time.Time) ([]tokens.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.inner.GetTokensByProfileID(ctx, profileID, since, before)
+}
+
+// ListTokensByProfileID retrieves up to NumTokenResults
+// tokens.RefreshTokens with a ProfileID matching `profileID` from the
+// wrapped Storer, along with whether more matched.
+func (s Storer) ListTokensByProfileID(ctx context.Context, profileID string, since, before time.Time, opts tokens.ListOptions) ([]tokens.RefreshToken, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return s.inner.ListTokensByProfileID(ctx, profileID, since, before, opts)
+}
+
+// GetTokensByProfileIDs retrieves up to NumTokenResults tokens.RefreshTokens
+// for each of `profileIDs` from the wrapped Storer, keyed by their
+// ProfileID.
+func (s Storer) GetTokensByProfileIDs(ctx context.Context, profileIDs []string, since, before time.Time) (map[string][]tokens.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.inner.GetTokensByProfileIDs(ctx, profileIDs, since, before)
+}
+
+// GetLatestToken returns the most recent live tokens.RefreshToken for
+// `profileID` and `clientID` from the wrapped Storer.
+func (s Storer) GetLatestToken(ctx context.Context, profileID, clientID string) (tokens.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	return s.inner.GetLatestToken(ctx, profileID, clientID)
+}
+
+// GetTokenHistory returns the history of the tokens.RefreshToken specified
+// by `id` from the wrapped Storer.
+func (s Storer) GetTokenHistory(ctx context.Context, id string) (tokens.TokenHistory, error) {
+	if err := ctx.Err(); err != nil {
+		return tokens.TokenHistory{}, err
+	}
+	return s.inner.GetTokenHistory(ctx, id)
+}
+
+// TokenStats returns the number of tokens.RefreshTokens in the wrapped
+// Storer, the number of those that have been revoked, and the number of
+// those that have been used.
+func (s Storer) TokenStats(ctx context.Context) (total, revoked, used int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+	return s.inner.TokenStats(ctx)
+}
+
+// WithTransaction calls `fn` with a Storer whose operations all take place
+// in a single transaction of the wrapped Storer, and which checks the
+// context.Context passed to each of them like Storer does. If the wrapped
+// Storer isn't a tokens.TxStorer, tokens.ErrTransactionsUnsupported is
+// returned without calling `fn`.
+func (s Storer) WithTransaction(ctx context.Context, fn func(tx tokens.Storer) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	txStorer, ok := s.inner.(tokens.TxStorer)
+	if !ok {
+		return tokens.ErrTransactionsUnsupported
+	}
+	return txStorer.WithTransaction(ctx, func(tx tokens.Storer) error {
+		return fn(Storer{inner: tx})
+	})
+}
@@ -0,0 +1,119 @@
+package deadline_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/deadline"
+	"lockbox.dev/tokens/storers/memory"
+)
+
+func TestCanceledContext(t *testing.T) {
+	t.Parallel()
+
+	inner, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	storer := deadline.NewStorer(inner)
+
+	token := tokens.RefreshToken{
+		ID:          "token",
+		CreatedAt:   time.Now().Add(-1 * time.Hour),
+		CreatedFrom: "deadline storer test case",
+		AccountID:   "account",
+		ProfileID:   "profile",
+		ClientID:    "client",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = storer.CreateToken(ctx, token)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from CreateToken, got %+v\n", err)
+	}
+	_, err = inner.GetToken(context.Background(), token.ID)
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected CreateToken not to reach the wrapped storer, got %+v\n", err)
+	}
+
+	err = storer.CreateToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+
+	_, err = storer.GetToken(ctx, token.ID)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from GetToken, got %+v\n", err)
+	}
+	revoked := true
+	_, err = storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: token.ID, Revoked: &revoked})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from UpdateTokens, got %+v\n", err)
+	}
+	err = storer.UseToken(ctx, token.ID)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from UseToken, got %+v\n", err)
+	}
+	_, err = storer.GetTokensByProfileID(ctx, token.ProfileID, time.Time{}, time.Time{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from GetTokensByProfileID, got %+v\n", err)
+	}
+	_, _, _, err = storer.TokenStats(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from TokenStats, got %+v\n", err)
+	}
+
+	result, err := storer.GetToken(context.Background(), token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+	}
+	if result.Revoked || result.Used {
+		t.Errorf("Expected canceled calls not to change the token, got %+v", result)
+	}
+}
+
+func TestExpiredDeadline(t *testing.T) {
+	t.Parallel()
+
+	inner, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	storer := deadline.NewStorer(inner)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-1*time.Second))
+	defer cancel()
+
+	_, err = storer.GetToken(ctx, "token")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %+v\n", err)
+	}
+}
+
+func TestWithTransactionUnsupported(t *testing.T) {
+	t.Parallel()
+
+	inner, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	// hide the memory Storer's WithTransaction method
+	storer := deadline.NewStorer(struct{ tokens.Storer }{inner})
+
+	var called bool
+	err = storer.WithTransaction(context.Background(), func(_ tokens.Storer) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, tokens.ErrTransactionsUnsupported) {
+		t.Errorf("Expected tokens.ErrTransactionsUnsupported, got %+v\n", err)
+	}
+	if called {
+		t.Error("Expected WithTransaction not to call its function")
+	}
+}
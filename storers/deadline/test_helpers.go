@@ -0,0 +1,26 @@
+package deadline
+
+import (
+	"context"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/memory"
+)
+
+// Factory is a generator of Storers for testing purposes. The Storers it
+// generates wrap a new, isolated, in-memory Storer.
+type Factory struct{}
+
+// NewStorer creates a new Storer wrapping an in-memory Storer for tests.
+func (Factory) NewStorer(_ context.Context) (tokens.Storer, error) { //nolint:ireturn // interface requires returning an interface
+	inner, err := memory.NewStorer()
+	if err != nil {
+		return nil, err
+	}
+	return NewStorer(inner), nil
+}
+
+// TeardownStorer does nothing and is only included to fill an interface.
+func (Factory) TeardownStorer() error {
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	memdb "github.com/hashicorp/go-memdb"
@@ -11,6 +12,8 @@ import (
 	"lockbox.dev/tokens"
 )
 
+var _ tokens.TxStorer = (*Storer)(nil)
+
 var (
 	schema = &memdb.DBSchema{
 		Tables: map[string]*memdb.TableSchema{
@@ -37,15 +40,46 @@ var (
 						Unique:  false,
 						Indexer: &memdb.StringFieldIndex{Field: "AccountID", Lowercase: true},
 					},
+					"familyID": &memdb.IndexSchema{
+						Name:         "familyID",
+						Unique:       false,
+						AllowMissing: true,
+						Indexer:      &memdb.StringFieldIndex{Field: "FamilyID", Lowercase: true},
+					},
+				},
+			},
+			"reuse": &memdb.TableSchema{
+				Name: "reuse",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "ID", Lowercase: true},
+					},
 				},
 			},
 		},
 	}
 )
 
+// reuseAttempts records how many times a used tokens.RefreshToken has been
+// presented again.
+type reuseAttempts struct {
+	ID       string
+	Attempts int
+}
+
 // Storer is an in-memory implementation of the Storer interface, for use in testing.
 type Storer struct {
 	db *memdb.MemDB
+
+	// txn is set when the Storer was created by WithTransaction, and
+	// all operations should use it instead of their own transaction.
+	txn *memdb.Txn
+
+	// watchers are the channels returned by Watch, which changed
+	// tokens.RefreshTokens are sent to.
+	watchers *watchers
 }
 
 // NewStorer returns an instance of Storer that is ready to be used as a Storer.
@@ -55,20 +89,179 @@ func NewStorer() (*Storer, error) {
 		return nil, err
 	}
 	return &Storer{
-		db: db,
+		db:       db,
+		watchers: &watchers{chans: map[chan tokens.RefreshToken]*watcher{}},
 	}, nil
 }
 
+// watchBuffer is how many changed tokens.RefreshTokens can be waiting to
+// be received from a channel returned by Watch before writes to the
+// Storer block.
+const watchBuffer = 16
+
+// watchers tracks the channels returned by Watch.
+type watchers struct {
+	lock  sync.Mutex
+	chans map[chan tokens.RefreshToken]*watcher
+}
+
+// watcher is a channel returned by Watch, along with the context that
+// ends it. Its lock is held while sending to the channel, so it isn't
+// closed mid-send.
+type watcher struct {
+	ctx    context.Context //nolint:containedctx // the context ends the watcher
+	ch     chan tokens.RefreshToken
+	lock   sync.Mutex
+	closed bool
+}
+
+// send sends `token` to the watcher, unless it's closed or its context is
+// done first.
+func (w *watcher) send(token tokens.RefreshToken) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.ch <- token:
+	case <-w.ctx.Done():
+	}
+}
+
+// close closes the watcher's channel, waiting for any send in progress
+// to finish.
+func (w *watcher) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	close(w.ch)
+}
+
+// notify sends the tokens.RefreshTokens created or updated by `changes`
+// to every watcher. The watchers are copied before sending, so a watcher
+// that isn't being received from only blocks the write that's notifying
+// it, not calls to Watch or watchers ending.
+func (w *watchers) notify(changes memdb.Changes) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	watching := make([]*watcher, 0, len(w.chans))
+	for _, watcher := range w.chans {
+		watching = append(watching, watcher)
+	}
+	w.lock.Unlock()
+	if len(watching) < 1 {
+		return
+	}
+	for _, change := range changes {
+		if change.Table != "token" || change.After == nil {
+			continue
+		}
+		token, ok := change.After.(*tokens.RefreshToken)
+		if !ok || token == nil {
+			continue
+		}
+		for _, watcher := range watching {
+			watcher.send(*token)
+		}
+	}
+}
+
+// Watch returns a channel that every tokens.RefreshToken created or
+// changed in the Storer is sent to, after the change is committed. The
+// channel is closed once `ctx` is done. Changes are buffered, but writes
+// to the Storer block while the buffer is full, so the channel should be
+// received from until `ctx` is done.
+func (m *Storer) Watch(ctx context.Context) (<-chan tokens.RefreshToken, error) {
+	ch := make(chan tokens.RefreshToken, watchBuffer)
+	w := &watcher{ctx: ctx, ch: ch}
+	m.watchers.lock.Lock()
+	m.watchers.chans[ch] = w
+	m.watchers.lock.Unlock()
+	go func() {
+		<-ctx.Done()
+		m.watchers.lock.Lock()
+		delete(m.watchers.chans, ch)
+		m.watchers.lock.Unlock()
+		w.close()
+	}()
+	return ch, nil
+}
+
+// writeTxn starts a write transaction that tracks its changes, so they
+// can be sent to watchers by commit.
+func (m *Storer) writeTxn() *memdb.Txn {
+	txn := m.db.Txn(true)
+	txn.TrackChanges()
+	return txn
+}
+
+// commit commits `txn`, then sends the changes it made to watchers.
+func (m *Storer) commit(txn *memdb.Txn) {
+	changes := txn.Changes()
+	txn.Commit()
+	m.watchers.notify(changes)
+}
+
+// readTxn returns the transaction that read operations should use, and
+// a function to call when the operation is done with it.
+func (m *Storer) readTxn() (*memdb.Txn, func()) {
+	if m.txn != nil {
+		return m.txn, func() {}
+	}
+	txn := m.db.Txn(false)
+	return txn, txn.Abort
+}
+
+// write calls `fn` with a write transaction, committing it if `fn`
+// doesn't return an error. If the Storer was created by WithTransaction,
+// `fn` uses that transaction, and committing is left to WithTransaction.
+func (m *Storer) write(fn func(txn *memdb.Txn) error) error {
+	if m.txn != nil {
+		return fn(m.txn)
+	}
+	txn := m.writeTxn()
+	defer txn.Abort()
+	err := fn(txn)
+	if err != nil {
+		return err
+	}
+	m.commit(txn)
+	return nil
+}
+
+// WithTransaction calls `fn` with a Storer whose operations all take place
+// in a single memdb write transaction, which is only committed if `fn`
+// returns nil. Only one write transaction can be open at a time, so `m`
+// must not be used from within `fn`; use the Storer passed to `fn`
+// instead.
+func (m *Storer) WithTransaction(_ context.Context, fn func(tx tokens.Storer) error) error {
+	if m.txn != nil {
+		return fn(m)
+	}
+	txn := m.writeTxn()
+	defer txn.Abort()
+	err := fn(&Storer{db: m.db, txn: txn, watchers: m.watchers})
+	if err != nil {
+		return err
+	}
+	m.commit(txn)
+	return nil
+}
+
 // GetToken retrieves the tokens.RefreshToken with an ID matching `token` from the Storer. If
 // no tokens.RefreshToken has that ID, an ErrTokenNotFound error is returned.
 func (m *Storer) GetToken(_ context.Context, token string) (tokens.RefreshToken, error) {
-	txn := m.db.Txn(false)
+	txn, done := m.readTxn()
+	defer done()
 	tok, err := txn.First("token", "id", token)
 	if err != nil {
 		return tokens.RefreshToken{}, err
 	}
 	if tok == nil {
-		return tokens.RefreshToken{}, tokens.ErrTokenNotFound
+		return tokens.RefreshToken{}, tokens.TokenNotFoundError{ID: token}
 	}
 	res, ok := tok.(*tokens.RefreshToken)
 	if !ok || res == nil {
@@ -77,41 +270,110 @@ func (m *Storer) GetToken(_ context.Context, token string) (tokens.RefreshToken,
 	return *res, nil
 }
 
+// GetTokens retrieves the tokens.RefreshTokens with IDs matching `ids` from the Storer, keyed by
+// their IDs. IDs that no tokens.RefreshToken has are left out of the result.
+func (m *Storer) GetTokens(_ context.Context, ids []string) (map[string]tokens.RefreshToken, error) {
+	txn, done := m.readTxn()
+	defer done()
+	res := make(map[string]tokens.RefreshToken, len(ids))
+	for _, id := range ids {
+		tok, err := txn.First("token", "id", id)
+		if err != nil {
+			return nil, err
+		}
+		if tok == nil {
+			continue
+		}
+		token, ok := tok.(*tokens.RefreshToken)
+		if !ok || token == nil {
+			return nil, fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+		}
+		res[token.ID] = *token
+	}
+	return res, nil
+}
+
+// nilIfEmpty returns nil if `scopes` is empty, so tokens.RefreshTokens
+// without scopes always come back with nil Scopes, matching the other
+// Storers.
+func nilIfEmpty(scopes []string) []string {
+	if len(scopes) < 1 {
+		return nil
+	}
+	return scopes
+}
+
 // CreateToken inserts the passed tokens.RefreshToken into the Storer. If a tokens.RefreshToken with
 // the same ID already exists in the Storer, an ErrTokenAlreadyExists error will be
 // returned, and the tokens.RefreshToken will not be inserted.
 func (m *Storer) CreateToken(_ context.Context, token tokens.RefreshToken) error {
-	txn := m.db.Txn(true)
-	defer txn.Abort()
-	exists, err := txn.First("token", "id", token.ID)
-	if err != nil {
-		return err
-	}
-	if exists != nil {
-		return tokens.ErrTokenAlreadyExists
-	}
-	err = txn.Insert("token", &token)
+	token.Scopes = nilIfEmpty(token.Scopes)
+	return m.write(func(txn *memdb.Txn) error {
+		exists, err := txn.First("token", "id", token.ID)
+		if err != nil {
+			return err
+		}
+		if exists != nil {
+			return tokens.ErrTokenAlreadyExists
+		}
+		return txn.Insert("token", &token)
+	})
+}
+
+// CreateOrGetToken inserts the passed tokens.RefreshToken into the Storer, unless a
+// tokens.RefreshToken with the same ID already exists. The stored tokens.RefreshToken is
+// returned, along with whether it was just created.
+func (m *Storer) CreateOrGetToken(_ context.Context, token tokens.RefreshToken) (tokens.RefreshToken, bool, error) {
+	token.Scopes = nilIfEmpty(token.Scopes)
+	res := token
+	var created bool
+	err := m.write(func(txn *memdb.Txn) error {
+		exists, err := txn.First("token", "id", token.ID)
+		if err != nil {
+			return err
+		}
+		if exists != nil {
+			existing, ok := exists.(*tokens.RefreshToken)
+			if !ok || existing == nil {
+				return fmt.Errorf("unexpected response type %T", exists) //nolint:goerr113 // error is logged, not handled
+			}
+			res = *existing
+			return nil
+		}
+		created = true
+		return txn.Insert("token", &token)
+	})
 	if err != nil {
-		return err
+		return tokens.RefreshToken{}, false, err
 	}
-	txn.Commit()
-	return nil
+	return res, created, nil
 }
 
 // UpdateTokens applies `change` to all the tokens.RefreshTokens in the Storer that match the ID,
-// ProfileID, or ClientID constraints of `change`.
-func (m *Storer) UpdateTokens(_ context.Context, change tokens.RefreshTokenChange) error {
+// ProfileID, ClientID, or AccountID constraints of `change`, returning the IDs of the
+// tokens.RefreshTokens that matched.
+func (m *Storer) UpdateTokens(_ context.Context, change tokens.RefreshTokenChange) ([]string, error) {
 	if change.IsEmpty() {
-		return nil
+		return nil, nil
 	}
 
 	if !change.HasFilter() {
-		return tokens.ErrNoTokenChangeFilter
+		return nil, tokens.ErrNoTokenChangeFilter
 	}
 
-	txn := m.db.Txn(true)
-	defer txn.Abort()
+	var ids []string
+	err := m.write(func(txn *memdb.Txn) error {
+		var err error
+		ids, err = updateTokens(txn, change)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
 
+func updateTokens(txn *memdb.Txn, change tokens.RefreshTokenChange) ([]string, error) {
 	var iter memdb.ResultIterator
 	var err error
 	if change.ID != "" && change.ProfileID == "" && change.ClientID == "" && change.AccountID == "" {
@@ -126,9 +388,10 @@ func (m *Storer) UpdateTokens(_ context.Context, change tokens.RefreshTokenChang
 		iter, err = txn.Get("token", "id")
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var ids []string
 	for {
 		token := iter.Next()
 		if token == nil {
@@ -136,62 +399,355 @@ func (m *Storer) UpdateTokens(_ context.Context, change tokens.RefreshTokenChang
 		}
 		tok, ok := token.(*tokens.RefreshToken)
 		if !ok || tok == nil {
-			return fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+			return nil, fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
 		}
-		if change.ID != "" && tok.ID != change.ID {
+		if !changeMatches(*tok, change) {
 			continue
 		}
-		if change.ProfileID != "" && tok.ProfileID != change.ProfileID {
-			continue
+		updated := tokens.ApplyChange(*tok, change)
+		err = txn.Insert("token", &updated)
+		if err != nil {
+			return nil, err
 		}
-		if change.ClientID != "" && tok.ClientID != change.ClientID {
-			continue
+		ids = append(ids, tok.ID)
+	}
+	return ids, nil
+}
+
+// changeMatches returns true if `tok` matches the ID, ProfileID, ClientID, and
+// AccountID constraints of `change`.
+func changeMatches(tok tokens.RefreshToken, change tokens.RefreshTokenChange) bool {
+	if change.ID != "" && tok.ID != change.ID {
+		return false
+	}
+	if change.ProfileID != "" && tok.ProfileID != change.ProfileID {
+		return false
+	}
+	if change.ClientID != "" && tok.ClientID != change.ClientID {
+		return false
+	}
+	if change.AccountID != "" && tok.AccountID != change.AccountID {
+		return false
+	}
+	return true
+}
+
+// UpdateTokensBatched applies `change` to all the tokens.RefreshTokens in the Storer that match
+// the ID, ProfileID, ClientID, or AccountID constraints of `change`, `batchSize` at a time in
+// order of their IDs, committing each batch in its own transaction. The number of
+// tokens.RefreshTokens that matched is returned. If `batchSize` is less than 1,
+// tokens.DefaultUpdateBatchSize is used. If the Storer was created by WithTransaction, all the
+// batches are part of that transaction.
+func (m *Storer) UpdateTokensBatched(_ context.Context, change tokens.RefreshTokenChange, batchSize int) (int, error) {
+	if change.IsEmpty() {
+		return 0, nil
+	}
+
+	if !change.HasFilter() {
+		return 0, tokens.ErrNoTokenChangeFilter
+	}
+
+	if batchSize < 1 {
+		batchSize = tokens.DefaultUpdateBatchSize
+	}
+
+	var total int
+	var after string
+	for {
+		var ids []string
+		err := m.write(func(txn *memdb.Txn) error {
+			var err error
+			ids, err = updateTokensBatch(txn, change, after, batchSize)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		total += len(ids)
+		if len(ids) < batchSize {
+			return total, nil
 		}
-		if change.AccountID != "" && tok.AccountID != change.AccountID {
+		after = ids[len(ids)-1]
+	}
+}
+
+// updateTokensBatch applies `change` to up to `batchSize` of the matching
+// tokens.RefreshTokens with IDs after `after`, returning their IDs in order.
+func updateTokensBatch(txn *memdb.Txn, change tokens.RefreshTokenChange, after string, batchSize int) ([]string, error) {
+	iter, err := txn.LowerBound("token", "id", after)
+	if err != nil {
+		return nil, err
+	}
+
+	// collect the batch before changing anything, so the inserts
+	// don't disturb the iterator
+	batch := make([]tokens.RefreshToken, 0, batchSize)
+	for len(batch) < batchSize {
+		token := iter.Next()
+		if token == nil {
+			break
+		}
+		tok, ok := token.(*tokens.RefreshToken)
+		if !ok || tok == nil {
+			return nil, fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+		}
+		if tok.ID == after || !changeMatches(*tok, change) {
 			continue
 		}
-		updated := tokens.ApplyChange(*tok, change)
+		batch = append(batch, *tok)
+	}
+
+	ids := make([]string, 0, len(batch))
+	for _, tok := range batch {
+		updated := tokens.ApplyChange(tok, change)
 		err = txn.Insert("token", &updated)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		ids = append(ids, tok.ID)
 	}
-	txn.Commit()
-	return nil
+	return ids, nil
 }
 
 // UseToken marks a tokens.RefreshToken as used, or returns a tokens.ErrTokenUsed
 // error if the tokens.RefreshToken was already marked used.
 func (m *Storer) UseToken(_ context.Context, id string) error {
-	txn := m.db.Txn(true)
-	defer txn.Abort()
+	return m.write(func(txn *memdb.Txn) error {
+		tok, err := txn.First("token", "id", id)
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			return tokens.TokenNotFoundError{ID: id}
+		}
+		found, ok := tok.(*tokens.RefreshToken)
+		if !ok || found == nil {
+			return fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+		}
 
-	tok, err := txn.First("token", "id", id)
+		if found.Used {
+			return tokens.TokenUsedError{ID: id}
+		}
+
+		used := true
+		updated := tokens.ApplyChange(*found, tokens.RefreshTokenChange{
+			Used: &used,
+		})
+		return txn.Insert("token", &updated)
+	})
+}
+
+// UseAndGetToken atomically marks the tokens.RefreshToken specified by `id` as used, returning it
+// as it was before it was used. A tokens.ErrTokenRevoked or tokens.ErrTokenUsed is returned if
+// the token has been revoked or used, and a tokens.ErrTokenNotFound if it doesn't exist in the
+// Storer.
+func (m *Storer) UseAndGetToken(_ context.Context, id string) (tokens.RefreshToken, error) {
+	var res tokens.RefreshToken
+	err := m.write(func(txn *memdb.Txn) error {
+		tok, err := txn.First("token", "id", id)
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			return tokens.TokenNotFoundError{ID: id}
+		}
+		found, ok := tok.(*tokens.RefreshToken)
+		if !ok || found == nil {
+			return fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+		}
+
+		if found.Revoked {
+			return tokens.TokenRevokedError{ID: id}
+		}
+		if found.Used {
+			return tokens.TokenUsedError{ID: id}
+		}
+
+		res = *found
+		used := true
+		updated := tokens.ApplyChange(*found, tokens.RefreshTokenChange{
+			Used: &used,
+		})
+		return txn.Insert("token", &updated)
+	})
 	if err != nil {
-		return err
-	}
-	if tok == nil {
-		return tokens.ErrTokenNotFound
+		return tokens.RefreshToken{}, err
 	}
-	found, ok := tok.(*tokens.RefreshToken)
-	if !ok || found == nil {
-		return fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+	return res, nil
+}
+
+// TouchToken atomically sets the CreatedAt of the tokens.RefreshToken specified by `id` to now,
+// returning a tokens.ErrTokenRevoked or tokens.ErrTokenUsed if the token has been revoked or used,
+// or a tokens.ErrTokenNotFound if the token doesn't exist in the Storer.
+func (m *Storer) TouchToken(_ context.Context, id string) error {
+	return m.write(func(txn *memdb.Txn) error {
+		tok, err := txn.First("token", "id", id)
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			return tokens.TokenNotFoundError{ID: id}
+		}
+		found, ok := tok.(*tokens.RefreshToken)
+		if !ok || found == nil {
+			return fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+		}
+
+		if found.Revoked {
+			return tokens.TokenRevokedError{ID: id}
+		}
+		if found.Used {
+			return tokens.TokenUsedError{ID: id}
+		}
+
+		updated := *found
+		updated.CreatedAt = time.Now()
+		return txn.Insert("token", &updated)
+	})
+}
+
+// RevokeTokens marks the tokens.RefreshTokens with IDs matching `ids` as revoked, returning how
+// many were revoked. tokens.RefreshTokens that were already revoked or don't exist aren't counted.
+func (m *Storer) RevokeTokens(_ context.Context, ids []string) (int, error) {
+	var revoked int
+	revoke := true
+	err := m.write(func(txn *memdb.Txn) error {
+		revoked = 0
+		for _, id := range ids {
+			tok, err := txn.First("token", "id", id)
+			if err != nil {
+				return err
+			}
+			if tok == nil {
+				continue
+			}
+			found, ok := tok.(*tokens.RefreshToken)
+			if !ok || found == nil {
+				return fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+			}
+			if found.Revoked {
+				continue
+			}
+			updated := tokens.ApplyChange(*found, tokens.RefreshTokenChange{
+				Revoked: &revoke,
+			})
+			err = txn.Insert("token", &updated)
+			if err != nil {
+				return err
+			}
+			revoked++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
+	return revoked, nil
+}
 
-	if found.Used {
-		return tokens.ErrTokenUsed
+// RevokeTokenFamily marks all the tokens.RefreshTokens with a FamilyID matching `familyID` as
+// revoked, returning how many were revoked. tokens.RefreshTokens that were already revoked aren't
+// counted. An empty `familyID` matches no tokens.RefreshTokens.
+func (m *Storer) RevokeTokenFamily(_ context.Context, familyID string) (int, error) {
+	if familyID == "" {
+		return 0, nil
 	}
+	var revoked int
+	revoke := true
+	err := m.write(func(txn *memdb.Txn) error {
+		revoked = 0
+		iter, err := txn.Get("token", "familyID", familyID)
+		if err != nil {
+			return err
+		}
+		var toks []tokens.RefreshToken
+		for {
+			tok := iter.Next()
+			if tok == nil {
+				break
+			}
+			token, ok := tok.(*tokens.RefreshToken)
+			if !ok || token == nil {
+				return fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+			}
+			if token.FamilyID != familyID || token.Revoked {
+				continue
+			}
+			toks = append(toks, *token)
+		}
+		for _, token := range toks {
+			updated := tokens.ApplyChange(token, tokens.RefreshTokenChange{
+				Revoked: &revoke,
+			})
+			err = txn.Insert("token", &updated)
+			if err != nil {
+				return err
+			}
+			revoked++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
 
-	used := true
-	updated := tokens.ApplyChange(*found, tokens.RefreshTokenChange{
-		Used: &used,
+// MarkTokenReuseAttempt records that the tokens.RefreshToken specified by `id` was presented
+// again after being used, returning the number of times that has happened. If the
+// tokens.RefreshToken doesn't exist in the Storer, a tokens.ErrTokenNotFound error is returned.
+func (m *Storer) MarkTokenReuseAttempt(_ context.Context, id string) (int, error) {
+	var attempts int
+	err := m.write(func(txn *memdb.Txn) error {
+		tok, err := txn.First("token", "id", id)
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			return tokens.TokenNotFoundError{ID: id}
+		}
+		attempts, err = getReuseAttempts(txn, id)
+		if err != nil {
+			return err
+		}
+		attempts++
+		return txn.Insert("reuse", &reuseAttempts{ID: id, Attempts: attempts})
 	})
-	err = txn.Insert("token", &updated)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	txn.Commit()
-	return nil
+	return attempts, nil
+}
+
+// GetReuseAttempts returns the number of times the tokens.RefreshToken specified by `id` has
+// been presented again after being used. If the tokens.RefreshToken doesn't exist in the
+// Storer, a tokens.ErrTokenNotFound error is returned.
+func (m *Storer) GetReuseAttempts(_ context.Context, id string) (int, error) {
+	txn, done := m.readTxn()
+	defer done()
+	tok, err := txn.First("token", "id", id)
+	if err != nil {
+		return 0, err
+	}
+	if tok == nil {
+		return 0, tokens.TokenNotFoundError{ID: id}
+	}
+	return getReuseAttempts(txn, id)
+}
+
+func getReuseAttempts(txn *memdb.Txn, id string) (int, error) {
+	reuse, err := txn.First("reuse", "id", id)
+	if err != nil {
+		return 0, err
+	}
+	if reuse == nil {
+		return 0, nil
+	}
+	res, ok := reuse.(*reuseAttempts)
+	if !ok || res == nil {
+		return 0, fmt.Errorf("unexpected response type %T", reuse) //nolint:goerr113 // error is logged, not handled
+	}
+	return res.Attempts, nil
 }
 
 // GetTokensByProfileID retrieves up to NumTokenResults tokens.RefreshTokens from the Storer. Only
@@ -200,14 +756,49 @@ func (m *Storer) UseToken(_ context.Context, id string) error {
 // If `before` is non-empty, only tokens.RefreshTokens with a CreatedAt property that is before `before`
 // will be returned. tokens.RefreshTokens will be sorted by their CreatedAt property, with the most recent
 // coming first.
-func (m *Storer) GetTokensByProfileID(_ context.Context, profileID string, since, before time.Time) ([]tokens.RefreshToken, error) {
-	txn := m.db.Txn(false)
-	defer txn.Abort()
+func (m *Storer) GetTokensByProfileID(ctx context.Context, profileID string, since, before time.Time) ([]tokens.RefreshToken, error) {
+	toks, _, err := m.ListTokensByProfileID(ctx, profileID, since, before, tokens.ListOptions{})
+	return toks, err
+}
+
+// ListTokensByProfileID retrieves the same tokens.RefreshTokens as GetTokensByProfileID, and also
+// reports whether more than NumTokenResults tokens.RefreshTokens matched, meaning some were left
+// out of the results. If `opts.Ascending` is true, the oldest tokens.RefreshTokens are returned
+// first. Revoked or used tokens.RefreshTokens are left out if `opts` excludes them.
+func (m *Storer) ListTokensByProfileID(_ context.Context, profileID string, since, before time.Time, opts tokens.ListOptions) ([]tokens.RefreshToken, bool, error) {
+	txn, done := m.readTxn()
+	defer done()
 
+	return listTokensByProfileID(txn, profileID, since, before, opts)
+}
+
+// GetTokensByProfileIDs retrieves up to NumTokenResults tokens.RefreshTokens for
+// each of `profileIDs` from the Storer, filtered and sorted like
+// GetTokensByProfileID, keyed by their ProfileID. Profiles without any matching
+// tokens.RefreshTokens are left out of the result.
+func (m *Storer) GetTokensByProfileIDs(_ context.Context, profileIDs []string, since, before time.Time) (map[string][]tokens.RefreshToken, error) {
+	txn, done := m.readTxn()
+	defer done()
+
+	res := make(map[string][]tokens.RefreshToken, len(profileIDs))
+	for _, profileID := range profileIDs {
+		toks, _, err := listTokensByProfileID(txn, profileID, since, before, tokens.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if len(toks) < 1 {
+			continue
+		}
+		res[profileID] = toks
+	}
+	return res, nil
+}
+
+func listTokensByProfileID(txn *memdb.Txn, profileID string, since, before time.Time, opts tokens.ListOptions) ([]tokens.RefreshToken, bool, error) {
 	var toks []tokens.RefreshToken
 	iter, err := txn.Get("token", "profileID", profileID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	for {
@@ -217,7 +808,7 @@ func (m *Storer) GetTokensByProfileID(_ context.Context, profileID string, since
 		}
 		token, ok := tok.(*tokens.RefreshToken)
 		if !ok || token == nil {
-			return nil, fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+			return nil, false, fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
 		}
 		if !before.IsZero() && !token.CreatedAt.Before(before) {
 			continue
@@ -225,11 +816,101 @@ func (m *Storer) GetTokensByProfileID(_ context.Context, profileID string, since
 		if !since.IsZero() && !token.CreatedAt.After(since) {
 			continue
 		}
+		if !opts.Includes(*token) {
+			continue
+		}
 		toks = append(toks, *token)
 	}
-	sort.Slice(toks, func(i, j int) bool { return toks[i].CreatedAt.After(toks[j].CreatedAt) })
+	if opts.Ascending {
+		sort.Slice(toks, func(i, j int) bool { return toks[i].CreatedAt.Before(toks[j].CreatedAt) })
+	} else {
+		sort.Slice(toks, func(i, j int) bool { return toks[i].CreatedAt.After(toks[j].CreatedAt) })
+	}
+	var hasMore bool
 	if len(toks) > tokens.NumTokenResults {
 		toks = toks[:tokens.NumTokenResults]
+		hasMore = true
+	}
+	return toks, hasMore, nil
+}
+
+// GetLatestToken returns the most recently created tokens.RefreshToken with a
+// ProfileID matching `profileID` and a ClientID matching `clientID` that
+// hasn't been revoked or used, or a tokens.ErrTokenNotFound error if there is
+// none.
+func (m *Storer) GetLatestToken(_ context.Context, profileID, clientID string) (tokens.RefreshToken, error) {
+	txn, done := m.readTxn()
+	defer done()
+
+	iter, err := txn.Get("token", "profileID", profileID)
+	if err != nil {
+		return tokens.RefreshToken{}, err
+	}
+
+	var latest *tokens.RefreshToken
+	for {
+		tok := iter.Next()
+		if tok == nil {
+			break
+		}
+		token, ok := tok.(*tokens.RefreshToken)
+		if !ok || token == nil {
+			return tokens.RefreshToken{}, fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+		}
+		if token.ClientID != clientID || token.Revoked || token.Used {
+			continue
+		}
+		if latest == nil || token.CreatedAt.After(latest.CreatedAt) {
+			latest = token
+		}
+	}
+	if latest == nil {
+		return tokens.RefreshToken{}, tokens.ErrTokenNotFound
+	}
+	return *latest, nil
+}
+
+// GetTokenHistory returns the tokens.RefreshToken specified by `id` along with
+// the events that have happened to it. The Storer doesn't record when
+// tokens.RefreshTokens are used or revoked, so the history is built from the
+// tokens.RefreshToken's current state, and only its creation has a time. If
+// the tokens.RefreshToken doesn't exist, a tokens.ErrTokenNotFound error is
+// returned.
+func (m *Storer) GetTokenHistory(ctx context.Context, id string) (tokens.TokenHistory, error) {
+	token, err := m.GetToken(ctx, id)
+	if err != nil {
+		return tokens.TokenHistory{}, err
+	}
+	return tokens.NewTokenHistory(token, time.Time{}, time.Time{}), nil
+}
+
+// TokenStats returns the number of tokens.RefreshTokens in the Storer, the
+// number of those that have been revoked, and the number of those that have
+// been used.
+func (m *Storer) TokenStats(_ context.Context) (total, revoked, used int, err error) {
+	txn, done := m.readTxn()
+	defer done()
+
+	iter, err := txn.Get("token", "id")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for {
+		tok := iter.Next()
+		if tok == nil {
+			break
+		}
+		token, ok := tok.(*tokens.RefreshToken)
+		if !ok || token == nil {
+			return 0, 0, 0, fmt.Errorf("unexpected response type %T", tok) //nolint:goerr113 // error is logged, not handled
+		}
+		total++
+		if token.Revoked {
+			revoked++
+		}
+		if token.Used {
+			used++
+		}
 	}
-	return toks, nil
+	return total, revoked, used, nil
 }
@@ -0,0 +1,150 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/memory"
+	"lockbox.dev/tokens/tokenstest"
+)
+
+func receive(t *testing.T, ch <-chan tokens.RefreshToken) tokens.RefreshToken {
+	t.Helper()
+	select {
+	case token, ok := <-ch:
+		if !ok {
+			t.Fatal("Watch channel closed unexpectedly")
+		}
+		return token
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for change")
+	}
+	return tokens.RefreshToken{}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := storer.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error watching storer: %+v\n", err)
+	}
+
+	token := tokenstest.NewToken(t)
+	err = storer.CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, receive(t, changes)); diff != "" {
+		t.Errorf("Unexpected diff in created token (-wanted, +got): %s", diff)
+	}
+
+	err = storer.UseToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Error using token: %+v\n", err)
+	}
+	expected := token
+	expected.Used = true
+	if diff := cmp.Diff(expected, receive(t, changes)); diff != "" {
+		t.Errorf("Unexpected diff in used token (-wanted, +got): %s", diff)
+	}
+
+	// changes made in transactions are sent once they're committed
+	other := tokenstest.NewToken(t)
+	err = storer.WithTransaction(ctx, func(tx tokens.Storer) error {
+		return tx.CreateToken(ctx, other)
+	})
+	if err != nil {
+		t.Fatalf("Error creating token in transaction: %+v\n", err)
+	}
+	if diff := cmp.Diff(other, receive(t, changes)); diff != "" {
+		t.Errorf("Unexpected diff in token created in transaction (-wanted, +got): %s", diff)
+	}
+
+	cancel()
+	select {
+	case token, ok := <-changes:
+		if ok {
+			t.Errorf("Expected channel to be closed, got %+v", token)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for channel to close")
+	}
+
+	// writes don't block once the watcher is gone
+	err = storer.CreateToken(context.Background(), tokenstest.NewToken(t))
+	if err != nil {
+		t.Fatalf("Error creating token after watch ended: %+v\n", err)
+	}
+}
+
+func TestWatchWhileWatcherBlocked(t *testing.T) {
+	t.Parallel()
+
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stuck, err := storer.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error watching storer: %+v\n", err)
+	}
+
+	// fill the buffer and a write past it, which blocks until the stuck
+	// watcher is received from or ends
+	written := make(chan error, 1)
+	go func() {
+		for i := 0; i < 17; i++ {
+			err := storer.CreateToken(context.Background(), tokenstest.NewToken(t))
+			if err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	// watching doesn't wait for the blocked write
+	otherCtx, otherCancel := context.WithCancel(context.Background())
+	defer otherCancel()
+	watched := make(chan error, 1)
+	go func() {
+		_, err := storer.Watch(otherCtx)
+		watched <- err
+	}()
+	select {
+	case err := <-watched:
+		if err != nil {
+			t.Fatalf("Unexpected error watching storer: %+v\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out watching storer while another watcher was blocked")
+	}
+
+	cancel()
+	otherCancel()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for blocked write to finish")
+	}
+	for range stuck {
+	}
+}
@@ -0,0 +1,524 @@
+package multi
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"yall.in"
+
+	"lockbox.dev/tokens"
+)
+
+var _ tokens.Storer = Storer{}
+
+// Storer is an implementation of the Storer interface that wraps two other
+// Storers, for use when migrating between them. Mutations are applied to
+// both Storers, and reads are served from the primary Storer, falling back
+// to the secondary Storer when the primary doesn't have the
+// tokens.RefreshToken being requested. Listing a profile's
+// tokens.RefreshTokens merges the results from both Storers, as a
+// profile's tokens.RefreshTokens may be split between them.
+//
+// By default, errors from the secondary Storer are logged but not
+// returned, so the secondary Storer can't fail operations against the
+// primary Storer. Setting FailOnSecondaryError to true will return those
+// errors instead.
+type Storer struct {
+	primary   tokens.Storer
+	secondary tokens.Storer
+
+	// FailOnSecondaryError controls whether errors from the secondary
+	// Storer are returned, both when writing to it and when merging its
+	// results into reads. If false, they're only logged, and reads return
+	// the primary Storer's results.
+	FailOnSecondaryError bool
+}
+
+// NewStorer returns an instance of Storer that is ready to be used as a
+// Storer. All reads will be served from `primary` when possible, and all
+// writes will be applied to both `primary` and `secondary`.
+func NewStorer(primary, secondary tokens.Storer) Storer {
+	return Storer{
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+// secondaryErr logs `err`, returned by the secondary Storer's `method`, and
+// returns it if FailOnSecondaryError is set. Callers reading from the
+// secondary Storer should carry on without its results if it returns nil. A tokens.ErrTokenNotFound
+// error just means the tokens.RefreshToken hasn't been copied to the
+// secondary Storer, and is ignored.
+func (s Storer) secondaryErr(ctx context.Context, method string, err error) error {
+	if err == nil || errors.Is(err, tokens.ErrTokenNotFound) {
+		return nil
+	}
+	yall.FromContext(ctx).WithError(err).WithField("method", method).Error("error from secondary storer")
+	if s.FailOnSecondaryError {
+		return err
+	}
+	return nil
+}
+
+// GetToken retrieves the tokens.RefreshToken with an ID matching `token`
+// from the primary Storer. If the primary Storer returns a
+// tokens.ErrTokenNotFound error, the secondary Storer will be consulted.
+func (s Storer) GetToken(ctx context.Context, token string) (tokens.RefreshToken, error) {
+	res, err := s.primary.GetToken(ctx, token)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.GetToken(ctx, token)
+	}
+	return res, err
+}
+
+// GetTokens retrieves the tokens.RefreshTokens with IDs matching `ids`
+// from the primary Storer, keyed by their IDs. Any IDs the primary Storer
+// doesn't have will be requested from the secondary Storer.
+func (s Storer) GetTokens(ctx context.Context, ids []string) (map[string]tokens.RefreshToken, error) {
+	res, err := s.primary.GetTokens(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	missing := make([]string, 0, len(ids)-len(res))
+	for _, id := range ids {
+		if _, ok := res[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) < 1 {
+		return res, nil
+	}
+	secondary, err := s.secondary.GetTokens(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for id, token := range secondary {
+		res[id] = token
+	}
+	return res, nil
+}
+
+// CreateToken inserts the passed tokens.RefreshToken into the primary
+// Storer and, if that succeeds, the secondary Storer.
+func (s Storer) CreateToken(ctx context.Context, token tokens.RefreshToken) error {
+	err := s.primary.CreateToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.secondaryErr(ctx, "CreateToken", s.secondary.CreateToken(ctx, token))
+}
+
+// CreateOrGetToken inserts the passed tokens.RefreshToken into the primary
+// Storer unless it already exists there, then does the same in the
+// secondary Storer. The primary Storer's result is returned.
+func (s Storer) CreateOrGetToken(ctx context.Context, token tokens.RefreshToken) (tokens.RefreshToken, bool, error) {
+	res, created, err := s.primary.CreateOrGetToken(ctx, token)
+	if err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	_, _, err = s.secondary.CreateOrGetToken(ctx, res)
+	err = s.secondaryErr(ctx, "CreateOrGetToken", err)
+	if err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	return res, created, nil
+}
+
+// UpdateTokens applies `change` to all the tokens.RefreshTokens in both the
+// primary and secondary Storers that match the ID, ProfileID, ClientID, or
+// AccountID constraints of `change`. The IDs of the matching
+// tokens.RefreshTokens in both Storers are returned.
+func (s Storer) UpdateTokens(ctx context.Context, change tokens.RefreshTokenChange) ([]string, error) {
+	ids, err := s.primary.UpdateTokens(ctx, change)
+	if err != nil {
+		return nil, err
+	}
+	secondaryIDs, err := s.secondary.UpdateTokens(ctx, change)
+	err = s.secondaryErr(ctx, "UpdateTokens", err)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		seen[id] = struct{}{}
+	}
+	for _, id := range secondaryIDs {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// UpdateTokensBatched applies `change` to all the tokens.RefreshTokens in
+// both the primary and secondary Storers that match the ID, ProfileID,
+// ClientID, or AccountID constraints of `change`. The primary Storer is
+// updated `batchSize` at a time; the secondary Storer is updated all at
+// once, like UpdateTokens, so the tokens.RefreshTokens only it has can be
+// told apart. The number of matching tokens.RefreshTokens in both Storers
+// is returned, counting those in both once.
+func (s Storer) UpdateTokensBatched(ctx context.Context, change tokens.RefreshTokenChange, batchSize int) (int, error) {
+	count, err := s.primary.UpdateTokensBatched(ctx, change, batchSize)
+	if err != nil {
+		return count, err
+	}
+	secondaryIDs, err := s.secondary.UpdateTokens(ctx, change)
+	err = s.secondaryErr(ctx, "UpdateTokensBatched", err)
+	if err != nil {
+		return count, err
+	}
+	missing, err := s.missingFromPrimary(ctx, secondaryIDs)
+	if err != nil {
+		return count, err
+	}
+	return count + len(missing), nil
+}
+
+// missingFromPrimary returns the IDs in `ids` that the primary Storer
+// doesn't have a tokens.RefreshToken for.
+func (s Storer) missingFromPrimary(ctx context.Context, ids []string) ([]string, error) {
+	if len(ids) < 1 {
+		return nil, nil
+	}
+	found, err := s.primary.GetTokens(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// UseToken marks the tokens.RefreshToken specified by `id` as used in
+// both Storers. If the tokens.RefreshToken only exists in the secondary
+// Storer, the secondary Storer's result is returned, as it is the only
+// authoritative source for that tokens.RefreshToken.
+func (s Storer) UseToken(ctx context.Context, id string) error {
+	err := s.primary.UseToken(ctx, id)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.UseToken(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	return s.secondaryErr(ctx, "UseToken", s.secondary.UseToken(ctx, id))
+}
+
+// UseAndGetToken atomically marks the tokens.RefreshToken specified by
+// `id` as used in the primary Storer, returning it as it was before it
+// was used, then marks it used in the secondary Storer. If the
+// tokens.RefreshToken only exists in the secondary Storer, the secondary
+// Storer's result is returned.
+func (s Storer) UseAndGetToken(ctx context.Context, id string) (tokens.RefreshToken, error) {
+	token, err := s.primary.UseAndGetToken(ctx, id)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.UseAndGetToken(ctx, id)
+	}
+	if err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	err = s.secondaryErr(ctx, "UseAndGetToken", s.secondary.UseToken(ctx, id))
+	if err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// TouchToken sets the CreatedAt of the tokens.RefreshToken specified by
+// `id` to now in the primary Storer, then copies the primary Storer's new
+// CreatedAt to the secondary Storer, so both agree on when its JWTs
+// expire. If the tokens.RefreshToken only exists in the secondary Storer,
+// the secondary Storer's result is returned.
+func (s Storer) TouchToken(ctx context.Context, id string) error {
+	err := s.primary.TouchToken(ctx, id)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.TouchToken(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	touched, err := s.primary.GetToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = s.secondary.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: id, CreatedAt: &touched.CreatedAt})
+	return s.secondaryErr(ctx, "TouchToken", err)
+}
+
+// RevokeTokens marks the tokens.RefreshTokens with IDs matching `ids` as
+// revoked in both Storers. The number of tokens.RefreshTokens revoked is
+// returned; those the primary Storer has are counted by it, and the rest
+// are counted by the secondary Storer, so none are counted twice.
+func (s Storer) RevokeTokens(ctx context.Context, ids []string) (int, error) {
+	missing, err := s.missingFromPrimary(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := s.primary.RevokeTokens(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	isMissing := make(map[string]struct{}, len(missing))
+	for _, id := range missing {
+		isMissing[id] = struct{}{}
+	}
+	present := make([]string, 0, len(ids)-len(missing))
+	for _, id := range ids {
+		if _, ok := isMissing[id]; !ok {
+			present = append(present, id)
+		}
+	}
+	if len(present) > 0 {
+		_, err = s.secondary.RevokeTokens(ctx, present)
+		err = s.secondaryErr(ctx, "RevokeTokens", err)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(missing) > 0 {
+		secondaryRevoked, err := s.secondary.RevokeTokens(ctx, missing)
+		err = s.secondaryErr(ctx, "RevokeTokens", err)
+		if err != nil {
+			return 0, err
+		}
+		revoked += secondaryRevoked
+	}
+	return revoked, nil
+}
+
+// RevokeTokenFamily marks the tokens.RefreshTokens with a FamilyID
+// matching `familyID` as revoked in both Storers. The family's
+// tokens.RefreshTokens are found through the profile of the
+// tokens.RefreshToken `familyID` identifies, and revoked like RevokeTokens
+// does, so none are counted twice. Any the listing missed, like those
+// created since, are revoked too, but only counted if they're in the
+// primary Storer.
+func (s Storer) RevokeTokenFamily(ctx context.Context, familyID string) (int, error) {
+	if familyID == "" {
+		return 0, nil
+	}
+	ids, err := s.liveFamilyIDs(ctx, familyID)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := s.RevokeTokens(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	late, err := s.primary.RevokeTokenFamily(ctx, familyID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.secondary.RevokeTokenFamily(ctx, familyID)
+	err = s.secondaryErr(ctx, "RevokeTokenFamily", err)
+	if err != nil {
+		return 0, err
+	}
+	return revoked + late, nil
+}
+
+// liveFamilyIDs returns the IDs of the unrevoked tokens.RefreshTokens in
+// either Storer with a FamilyID of `familyID`. Every tokens.RefreshToken
+// in a family has the same ProfileID as the one that started it, which
+// has `familyID` as its ID, so that profile's tokens.RefreshTokens are
+// listed until they run out. If the tokens.RefreshToken that started the
+// family can't be found, nil is returned.
+func (s Storer) liveFamilyIDs(ctx context.Context, familyID string) ([]string, error) {
+	first, err := s.GetToken(ctx, familyID)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	var ids []string
+	includeRevoked := false
+	opts := tokens.ListOptions{IncludeRevoked: &includeRevoked}
+	for i, storer := range []tokens.Storer{s.primary, s.secondary} {
+		var before time.Time
+		for {
+			toks, hasMore, err := storer.ListTokensByProfileID(ctx, first.ProfileID, time.Time{}, before, opts)
+			if i > 0 {
+				err = s.secondaryErr(ctx, "RevokeTokenFamily", err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, token := range toks {
+				if _, ok := seen[token.ID]; ok || token.FamilyID != familyID {
+					continue
+				}
+				seen[token.ID] = struct{}{}
+				ids = append(ids, token.ID)
+			}
+			if !hasMore || len(toks) < 1 {
+				break
+			}
+			before = toks[len(toks)-1].CreatedAt
+		}
+	}
+	return ids, nil
+}
+
+// MarkTokenReuseAttempt records a reuse attempt for the
+// tokens.RefreshToken specified by `id` in both Storers, returning the
+// primary Storer's count. If the tokens.RefreshToken only exists in the
+// secondary Storer, the secondary Storer's result is returned.
+func (s Storer) MarkTokenReuseAttempt(ctx context.Context, id string) (int, error) {
+	attempts, err := s.primary.MarkTokenReuseAttempt(ctx, id)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.MarkTokenReuseAttempt(ctx, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.secondary.MarkTokenReuseAttempt(ctx, id)
+	err = s.secondaryErr(ctx, "MarkTokenReuseAttempt", err)
+	if err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// GetReuseAttempts returns the number of reuse attempts recorded for the
+// tokens.RefreshToken specified by `id` in the primary Storer. If the
+// primary Storer returns a tokens.ErrTokenNotFound error, the secondary
+// Storer will be consulted.
+func (s Storer) GetReuseAttempts(ctx context.Context, id string) (int, error) {
+	attempts, err := s.primary.GetReuseAttempts(ctx, id)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.GetReuseAttempts(ctx, id)
+	}
+	return attempts, err
+}
+
+// GetTokensByProfileID retrieves up to NumTokenResults tokens.RefreshTokens
+// from both Storers, using the same filtering and sorting as the
+// underlying Storers. The results are merged, preferring the primary
+// Storer's copy of tokens.RefreshTokens in both, and capped at
+// NumTokenResults. If the secondary Storer fails, only the primary
+// Storer's results are returned, unless FailOnSecondaryError is set.
+func (s Storer) GetTokensByProfileID(ctx context.Context, profileID string, since, before time.Time) ([]tokens.RefreshToken, error) {
+	toks, err := s.primary.GetTokensByProfileID(ctx, profileID, since, before)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := s.secondary.GetTokensByProfileID(ctx, profileID, since, before)
+	err = s.secondaryErr(ctx, "GetTokensByProfileID", err)
+	if err != nil {
+		return nil, err
+	}
+	toks, _ = mergeTokens(toks, secondary, false)
+	return toks, nil
+}
+
+// ListTokensByProfileID retrieves the same tokens.RefreshTokens as
+// GetTokensByProfileID, ordered according to `opts`, along with whether
+// either Storer had more matching tokens.RefreshTokens than were
+// returned.
+func (s Storer) ListTokensByProfileID(ctx context.Context, profileID string, since, before time.Time, opts tokens.ListOptions) ([]tokens.RefreshToken, bool, error) {
+	toks, hasMore, err := s.primary.ListTokensByProfileID(ctx, profileID, since, before, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	secondary, secondaryHasMore, err := s.secondary.ListTokensByProfileID(ctx, profileID, since, before, opts)
+	err = s.secondaryErr(ctx, "ListTokensByProfileID", err)
+	if err != nil {
+		return nil, false, err
+	}
+	toks, truncated := mergeTokens(toks, secondary, opts.Ascending)
+	return toks, hasMore || secondaryHasMore || truncated, nil
+}
+
+// GetTokensByProfileIDs retrieves the tokens.RefreshTokens for each of
+// `profileIDs` from both Storers, keyed by their ProfileID, merging each
+// profile's tokens.RefreshTokens like GetTokensByProfileID does.
+func (s Storer) GetTokensByProfileIDs(ctx context.Context, profileIDs []string, since, before time.Time) (map[string][]tokens.RefreshToken, error) {
+	res, err := s.primary.GetTokensByProfileIDs(ctx, profileIDs, since, before)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := s.secondary.GetTokensByProfileIDs(ctx, profileIDs, since, before)
+	err = s.secondaryErr(ctx, "GetTokensByProfileIDs", err)
+	if err != nil {
+		return nil, err
+	}
+	for id, toks := range secondary {
+		res[id], _ = mergeTokens(res[id], toks, false)
+	}
+	return res, nil
+}
+
+// mergeTokens combines `primary` and `secondary`, leaving out the
+// tokens.RefreshTokens in `secondary` that are also in `primary`, sorts
+// them by CreatedAt, most recent first unless `ascending` is true, and
+// caps them at tokens.NumTokenResults. Whether any were cut off by the
+// cap is returned.
+func mergeTokens(primary, secondary []tokens.RefreshToken, ascending bool) ([]tokens.RefreshToken, bool) {
+	if len(primary)+len(secondary) < 1 {
+		// match the underlying Storers, which return nil when
+		// there are no results
+		return nil, false
+	}
+	seen := make(map[string]struct{}, len(primary))
+	res := make([]tokens.RefreshToken, 0, len(primary)+len(secondary))
+	for _, token := range primary {
+		seen[token.ID] = struct{}{}
+		res = append(res, token)
+	}
+	for _, token := range secondary {
+		if _, ok := seen[token.ID]; ok {
+			continue
+		}
+		res = append(res, token)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if ascending {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].CreatedAt.After(res[j].CreatedAt)
+	})
+	if len(res) > tokens.NumTokenResults {
+		return res[:tokens.NumTokenResults], true
+	}
+	return res, false
+}
+
+// GetLatestToken returns the most recent live tokens.RefreshToken for
+// `profileID` and `clientID` from the primary Storer. If the primary Storer
+// returns a tokens.ErrTokenNotFound error, the secondary Storer will be
+// consulted.
+func (s Storer) GetLatestToken(ctx context.Context, profileID, clientID string) (tokens.RefreshToken, error) {
+	res, err := s.primary.GetLatestToken(ctx, profileID, clientID)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.GetLatestToken(ctx, profileID, clientID)
+	}
+	return res, err
+}
+
+// GetTokenHistory returns the history of the tokens.RefreshToken specified
+// by `id` from the primary Storer. If the primary Storer returns a
+// tokens.ErrTokenNotFound error, the secondary Storer will be consulted.
+func (s Storer) GetTokenHistory(ctx context.Context, id string) (tokens.TokenHistory, error) {
+	res, err := s.primary.GetTokenHistory(ctx, id)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return s.secondary.GetTokenHistory(ctx, id)
+	}
+	return res, err
+}
+
+// TokenStats returns the token counts from the primary Storer only, without
+// falling back to the secondary Storer, as tokens.RefreshTokens in both
+// would be counted twice. During a migration, the counts leave out any
+// tokens.RefreshTokens that haven't been copied to the primary Storer yet.
+func (s Storer) TokenStats(ctx context.Context) (total, revoked, used int, err error) {
+	return s.primary.TokenStats(ctx)
+}
@@ -0,0 +1,430 @@
+package multi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/memory"
+	"lockbox.dev/tokens/storers/multi"
+	"lockbox.dev/tokens/tokenstest"
+)
+
+var errSecondary = errors.New("secondary storer failure")
+
+// failingStorer is a tokens.Storer whose mutations and profile reads
+// always fail.
+type failingStorer struct {
+	tokens.Storer
+}
+
+func (failingStorer) GetTokensByProfileID(_ context.Context, _ string, _, _ time.Time) ([]tokens.RefreshToken, error) {
+	return nil, errSecondary
+}
+
+func (failingStorer) ListTokensByProfileID(_ context.Context, _ string, _, _ time.Time, _ tokens.ListOptions) ([]tokens.RefreshToken, bool, error) {
+	return nil, false, errSecondary
+}
+
+func (failingStorer) GetTokensByProfileIDs(_ context.Context, _ []string, _, _ time.Time) (map[string][]tokens.RefreshToken, error) {
+	return nil, errSecondary
+}
+
+func (failingStorer) CreateToken(_ context.Context, _ tokens.RefreshToken) error {
+	return errSecondary
+}
+
+func (failingStorer) UpdateTokens(_ context.Context, _ tokens.RefreshTokenChange) ([]string, error) {
+	return nil, errSecondary
+}
+
+func (failingStorer) UseToken(_ context.Context, _ string) error {
+	return errSecondary
+}
+
+func newMemoryStorer(t *testing.T) *memory.Storer {
+	t.Helper()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	return storer
+}
+
+func TestGetTokenFallsBackToSecondary(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary, secondary := newMemoryStorer(t), newMemoryStorer(t)
+	storer := multi.NewStorer(primary, secondary)
+
+	token := tokenstest.NewToken(t)
+	err := secondary.CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+
+	result, err := storer.GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	profileToks, err := storer.GetTokensByProfileID(ctx, token.ProfileID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving tokens by profile ID: %+v\n", err)
+	}
+	if diff := cmp.Diff([]tokens.RefreshToken{token}, profileToks); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	_, err = storer.GetToken(ctx, tokenstest.NewToken(t).ID)
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected tokens.ErrTokenNotFound, got %+v\n", err)
+	}
+
+	primaryToken := tokenstest.NewToken(t)
+	err = primary.CreateToken(ctx, primaryToken)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	toks, err := storer.GetTokens(ctx, []string{primaryToken.ID, token.ID, tokenstest.NewToken(t).ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving tokens: %+v\n", err)
+	}
+	expected := map[string]tokens.RefreshToken{primaryToken.ID: primaryToken, token.ID: token}
+	if diff := cmp.Diff(expected, toks); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+}
+
+func TestWritesFanOut(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary, secondary := newMemoryStorer(t), newMemoryStorer(t)
+	storer := multi.NewStorer(primary, secondary)
+
+	token := tokenstest.NewToken(t)
+	err := storer.CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	revoked := true
+	_, err = storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: token.ID, Revoked: &revoked})
+	if err != nil {
+		t.Fatalf("Error updating token: %+v\n", err)
+	}
+	err = storer.UseToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Error using token: %+v\n", err)
+	}
+
+	expected := token
+	expected.Revoked = true
+	expected.Used = true
+	for name, s := range map[string]tokens.Storer{"primary": primary, "secondary": secondary} {
+		result, err := s.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token from %s: %+v\n", name, err)
+		}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("Unexpected diff in %s (-wanted, +got): %s", name, diff)
+		}
+	}
+}
+
+func TestFailingSecondary(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary := newMemoryStorer(t)
+	storer := multi.NewStorer(primary, failingStorer{})
+
+	token := tokenstest.NewToken(t)
+	err := storer.CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating token with failing secondary: %+v\n", err)
+	}
+	revoked := true
+	_, err = storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: token.ID, Revoked: &revoked})
+	if err != nil {
+		t.Fatalf("Unexpected error updating token with failing secondary: %+v\n", err)
+	}
+	err = storer.UseToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error using token with failing secondary: %+v\n", err)
+	}
+	result, err := primary.GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token from primary: %+v\n", err)
+	}
+	if !result.Revoked || !result.Used {
+		t.Errorf("Expected primary to have the token revoked and used, got %+v\n", result)
+	}
+
+	storer.FailOnSecondaryError = true
+	err = storer.CreateToken(ctx, tokenstest.NewToken(t))
+	if !errors.Is(err, errSecondary) {
+		t.Errorf("Expected secondary error creating token, got %+v\n", err)
+	}
+	_, err = storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: token.ID, Revoked: &revoked})
+	if !errors.Is(err, errSecondary) {
+		t.Errorf("Expected secondary error updating token, got %+v\n", err)
+	}
+}
+
+func TestProfileTokensMergedAcrossStorers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary, secondary := newMemoryStorer(t), newMemoryStorer(t)
+	storer := multi.NewStorer(primary, secondary)
+
+	profileID := tokenstest.NewToken(t).ProfileID
+	now := time.Now().Round(time.Millisecond)
+	var expected []tokens.RefreshToken
+	for i := 0; i < tokens.NumTokenResults+5; i++ {
+		token := tokenstest.NewToken(t, tokenstest.WithProfileID(profileID), tokenstest.WithCreatedAt(now.Add(time.Duration(-i)*time.Minute)))
+		target := primary
+		if i%2 == 1 {
+			target = secondary
+		}
+		err := target.CreateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+		expected = append(expected, token)
+	}
+	// a token in both shouldn't be listed twice
+	err := secondary.CreateToken(ctx, expected[0])
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+
+	toks, err := storer.GetTokensByProfileID(ctx, profileID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving tokens by profile ID: %+v\n", err)
+	}
+	if diff := cmp.Diff(expected[:tokens.NumTokenResults], toks); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	toks, hasMore, err := storer.ListTokensByProfileID(ctx, profileID, time.Time{}, time.Time{}, tokens.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error listing tokens by profile ID: %+v\n", err)
+	}
+	if diff := cmp.Diff(expected[:tokens.NumTokenResults], toks); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+	if !hasMore {
+		t.Error("Expected more tokens to be available")
+	}
+
+	byProfile, err := storer.GetTokensByProfileIDs(ctx, []string{profileID}, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving tokens by profile IDs: %+v\n", err)
+	}
+	if diff := cmp.Diff(map[string][]tokens.RefreshToken{profileID: expected[:tokens.NumTokenResults]}, byProfile); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+}
+
+func TestProfileReadsWithFailingSecondary(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary := newMemoryStorer(t)
+	storer := multi.NewStorer(primary, failingStorer{})
+
+	token := tokenstest.NewToken(t)
+	err := primary.CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	expected := []tokens.RefreshToken{token}
+
+	toks, err := storer.GetTokensByProfileID(ctx, token.ProfileID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving tokens by profile ID: %+v\n", err)
+	}
+	if diff := cmp.Diff(expected, toks); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+	toks, _, err = storer.ListTokensByProfileID(ctx, token.ProfileID, time.Time{}, time.Time{}, tokens.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error listing tokens by profile ID: %+v\n", err)
+	}
+	if diff := cmp.Diff(expected, toks); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+	byProfile, err := storer.GetTokensByProfileIDs(ctx, []string{token.ProfileID}, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving tokens by profile IDs: %+v\n", err)
+	}
+	if diff := cmp.Diff(map[string][]tokens.RefreshToken{token.ProfileID: expected}, byProfile); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	storer.FailOnSecondaryError = true
+	_, err = storer.GetTokensByProfileID(ctx, token.ProfileID, time.Time{}, time.Time{})
+	if !errors.Is(err, errSecondary) {
+		t.Errorf("Expected secondary error retrieving tokens by profile ID, got %+v\n", err)
+	}
+	_, _, err = storer.ListTokensByProfileID(ctx, token.ProfileID, time.Time{}, time.Time{}, tokens.ListOptions{})
+	if !errors.Is(err, errSecondary) {
+		t.Errorf("Expected secondary error listing tokens by profile ID, got %+v\n", err)
+	}
+	_, err = storer.GetTokensByProfileIDs(ctx, []string{token.ProfileID}, time.Time{}, time.Time{})
+	if !errors.Is(err, errSecondary) {
+		t.Errorf("Expected secondary error retrieving tokens by profile IDs, got %+v\n", err)
+	}
+}
+
+func TestCountsAcrossStorers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary, secondary := newMemoryStorer(t), newMemoryStorer(t)
+	storer := multi.NewStorer(primary, secondary)
+
+	// each case has a token in both storers, one only in the primary,
+	// and one only in the secondary, which should be counted once each
+	create := func(opts ...tokenstest.Option) []string {
+		both := tokenstest.NewToken(t, opts...)
+		err := storer.CreateToken(ctx, both)
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+		primaryOnly := tokenstest.NewToken(t, opts...)
+		err = primary.CreateToken(ctx, primaryOnly)
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+		secondaryOnly := tokenstest.NewToken(t, opts...)
+		err = secondary.CreateToken(ctx, secondaryOnly)
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+		return []string{both.ID, primaryOnly.ID, secondaryOnly.ID}
+	}
+
+	ids := create()
+	revoked, err := storer.RevokeTokens(ctx, append(ids, tokenstest.NewToken(t).ID))
+	if err != nil {
+		t.Fatalf("Unexpected error revoking tokens: %+v\n", err)
+	}
+	if revoked != 3 {
+		t.Errorf("Expected %d tokens revoked, got %d", 3, revoked)
+	}
+
+	profileID := tokenstest.NewToken(t).ProfileID
+	first := tokenstest.NewToken(t, tokenstest.WithProfileID(profileID))
+	err = storer.CreateToken(ctx, first)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	create(tokenstest.WithProfileID(profileID), tokenstest.WithFamilyID(first.ID))
+	// a token in the same profile but another family shouldn't be revoked
+	other := tokenstest.NewToken(t, tokenstest.WithProfileID(profileID))
+	err = storer.CreateToken(ctx, other)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	revoked, err = storer.RevokeTokenFamily(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error revoking token family: %+v\n", err)
+	}
+	if revoked != 4 {
+		t.Errorf("Expected %d tokens revoked, got %d", 4, revoked)
+	}
+	got, err := storer.GetToken(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+	}
+	if got.Revoked {
+		t.Errorf("Expected token in another family not to be revoked, got %+v", got)
+	}
+
+	accountID := tokenstest.NewToken(t).AccountID
+	create(tokenstest.WithAccountID(accountID))
+	scopes := []string{"https://test.lockbox.dev/updated"}
+	updated, err := storer.UpdateTokensBatched(ctx, tokens.RefreshTokenChange{AccountID: accountID, Scopes: &scopes}, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error updating tokens: %+v\n", err)
+	}
+	if updated != 3 {
+		t.Errorf("Expected %d tokens updated, got %d", 3, updated)
+	}
+}
+
+func TestWritesToTokensMissingFromSecondary(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary, secondary := newMemoryStorer(t), newMemoryStorer(t)
+	storer := multi.NewStorer(primary, secondary)
+	storer.FailOnSecondaryError = true
+
+	for _, id := range []string{"use", "use-and-get", "touch", "reuse"} {
+		err := primary.CreateToken(ctx, tokenstest.NewToken(t, tokenstest.WithID(id)))
+		if err != nil {
+			t.Fatalf("Error creating token: %+v\n", err)
+		}
+	}
+	err := storer.UseToken(ctx, "use")
+	if err != nil {
+		t.Errorf("Unexpected error using token missing from secondary: %+v\n", err)
+	}
+	_, err = storer.UseAndGetToken(ctx, "use-and-get")
+	if err != nil {
+		t.Errorf("Unexpected error using and getting token missing from secondary: %+v\n", err)
+	}
+	err = storer.TouchToken(ctx, "touch")
+	if err != nil {
+		t.Errorf("Unexpected error touching token missing from secondary: %+v\n", err)
+	}
+	_, err = storer.MarkTokenReuseAttempt(ctx, "reuse")
+	if err != nil {
+		t.Errorf("Unexpected error marking reuse attempt for token missing from secondary: %+v\n", err)
+	}
+}
+
+func TestTouchTokenMatchesAcrossStorers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	primary, secondary := newMemoryStorer(t), newMemoryStorer(t)
+	storer := multi.NewStorer(primary, secondary)
+
+	token := tokenstest.NewToken(t)
+	err := storer.CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	err = storer.TouchToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error touching token: %+v\n", err)
+	}
+
+	primaryResult, err := primary.GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token from primary: %+v\n", err)
+	}
+	secondaryResult, err := secondary.GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token from secondary: %+v\n", err)
+	}
+	if !primaryResult.CreatedAt.After(token.CreatedAt) {
+		t.Errorf("Expected touched token's CreatedAt %s to be after %s", primaryResult.CreatedAt, token.CreatedAt)
+	}
+	if diff := cmp.Diff(primaryResult, secondaryResult); diff != "" {
+		t.Errorf("Unexpected diff (-primary, +secondary): %s", diff)
+	}
+}
@@ -0,0 +1,32 @@
+package multi
+
+import (
+	"context"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/memory"
+)
+
+// Factory is a generator of Storers for testing purposes. The Storers it
+// generates wrap two new, isolated, in-memory Storers.
+type Factory struct{}
+
+// NewStorer creates a new Storer wrapping two in-memory Storers for tests.
+func (Factory) NewStorer(_ context.Context) (tokens.Storer, error) { //nolint:ireturn // interface requires returning an interface
+	primary, err := memory.NewStorer()
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := memory.NewStorer()
+	if err != nil {
+		return nil, err
+	}
+	storer := NewStorer(primary, secondary)
+	storer.FailOnSecondaryError = true
+	return storer, nil
+}
+
+// TeardownStorer does nothing and is only included to fill an interface.
+func (Factory) TeardownStorer() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"darlinggo.co/pan"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"lockbox.dev/tokens/storers/postgres/migrations"
+)
+
+var (
+	// ErrInvalidTableName is returned when Migrations is asked for
+	// migrations targeting a table whose name isn't a plain PostgreSQL
+	// identifier.
+	ErrInvalidTableName = errors.New("invalid table name")
+
+	tableNameRe       = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	migrationTableRe  = regexp.MustCompile(`\b(TABLE|ON|UPDATE) ` + DefaultTableName + `\b`)
+	migrationUniqueRe = regexp.MustCompile(`\bunique_value\b`)
+	migrationIndexRe  = regexp.MustCompile(`\b` + DefaultTableName + `(_\w+_idx)\b`)
+)
+
+func migrationSource() *migrate.AssetMigrationSource {
+	return &migrate.AssetMigrationSource{
+		Asset:    migrations.Asset,
+		AssetDir: migrations.AssetDir,
+		Dir:      "sql",
+	}
+}
+
+// Migrations returns the migrations that create and update the table
+// tokens are stored in, targeting `table` instead of DefaultTableName.
+// The IDs of the migrations are prefixed with `table`, so migrations for
+// more than one table can be applied to the same database. The
+// migrations for DefaultTableName are returned unchanged.
+func Migrations(table string) (*migrate.MemoryMigrationSource, error) {
+	if !tableNameRe.MatchString(table) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTableName, table)
+	}
+	migs, err := migrationSource().FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if table == DefaultTableName {
+		return &migrate.MemoryMigrationSource{Migrations: migs}, nil
+	}
+	rewrite := func(stmts []string) []string {
+		res := make([]string, 0, len(stmts))
+		for _, stmt := range stmts {
+			stmt = migrationTableRe.ReplaceAllString(stmt, "$1 "+table)
+			stmt = migrationUniqueRe.ReplaceAllString(stmt, table+"_unique_value")
+			stmt = migrationIndexRe.ReplaceAllString(stmt, table+"$1")
+			res = append(res, stmt)
+		}
+		return res
+	}
+	for _, mig := range migs {
+		mig.Id = table + strings.TrimPrefix(mig.Id, DefaultTableName)
+		mig.Up = rewrite(mig.Up)
+		mig.Down = rewrite(mig.Down)
+	}
+	return &migrate.MemoryMigrationSource{Migrations: migs}, nil
+}
+
+func backfillAccountIDSQL(_ context.Context, table, profileID, accountID string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("UPDATE " + pan.Table(t) + " SET ")
+	query.Comparison(t, "AccountID", "=", accountID)
+	query.Flush(" ").Where()
+	query.Comparison(t, "ProfileID", "=", profileID)
+	query.Comparison(t, "AccountID", "=", "")
+	return query.Flush(" AND ")
+}
+
+// MigrateLegacyTokens brings a database written by older versions of the
+// tokens service, from before tokens had an AccountID, up to the schema
+// Storer expects. It applies any migrations that haven't been applied yet,
+// then sets the AccountID of every token whose ProfileID is a key in
+// `accountIDs` to the corresponding value. Tokens whose ProfileID isn't in
+// `accountIDs` keep an empty AccountID.
+//
+// Only tokens with an empty AccountID are updated, so MigrateLegacyTokens
+// is safe to run more than once, and can be run again to resume after a
+// failure. The number of tokens updated is returned.
+//
+// `opts` are the Options the Storer using the database will be created
+// with, so the right table is migrated.
+func MigrateLegacyTokens(ctx context.Context, db *sql.DB, accountIDs map[string]string, opts ...Option) (int64, error) {
+	table := NewStorer(ctx, db, opts...).tableName()
+	migs, err := Migrations(table)
+	if err != nil {
+		return 0, err
+	}
+	_, err = migrate.Exec(db, "postgres", migs, migrate.Up)
+	if err != nil {
+		return 0, err
+	}
+
+	profileIDs := make([]string, 0, len(accountIDs))
+	for profileID := range accountIDs {
+		profileIDs = append(profileIDs, profileID)
+	}
+	sort.Strings(profileIDs)
+
+	var updated int64
+	for _, profileID := range profileIDs {
+		if accountIDs[profileID] == "" {
+			continue
+		}
+		query := backfillAccountIDSQL(ctx, table, profileID, accountIDs[profileID])
+		queryStr, err := query.PostgreSQLString()
+		if err != nil {
+			return updated, err
+		}
+		res, err := db.ExecContext(ctx, queryStr, query.Args()...)
+		if err != nil {
+			return updated, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return updated, err
+		}
+		updated += rows
+	}
+	return updated, nil
+}
@@ -0,0 +1,285 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	uuid "github.com/hashicorp/go-uuid"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/postgres"
+	"lockbox.dev/tokens/storers/postgres/migrations"
+)
+
+// legacyMigrations is the number of migrations that had been written
+// before tokens had an AccountID.
+const legacyMigrations = 3
+
+func newTestDatabase(t testing.TB) *sql.DB {
+	t.Helper()
+	if os.Getenv(postgres.TestConnStringEnvVar) == "" {
+		t.Skipf("%s not set, skipping PostgreSQL tests", postgres.TestConnStringEnvVar)
+	}
+	connString, err := url.Parse(os.Getenv(postgres.TestConnStringEnvVar))
+	if err != nil {
+		t.Fatalf("Error parsing %s as a URL: %+v\n", postgres.TestConnStringEnvVar, err)
+	}
+	control, err := sql.Open("postgres", connString.String())
+	if err != nil {
+		t.Fatalf("Error connecting to PostgreSQL: %+v\n", err)
+	}
+	suffix, err := uuid.GenerateRandomBytes(6)
+	if err != nil {
+		t.Fatalf("Error generating database suffix: %+v\n", err)
+	}
+	database := "tokens_test_" + hex.EncodeToString(suffix)
+	_, err = control.Exec("CREATE DATABASE " + database + ";")
+	if err != nil {
+		t.Fatalf("Error creating database %s: %+v\n", database, err)
+	}
+	connString.Path = "/" + database
+	db, err := sql.Open("postgres", connString.String())
+	if err != nil {
+		t.Fatalf("Error connecting to database %s: %+v\n", database, err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Error closing connection to %s: %+v\n", database, err)
+		}
+		if _, err := control.Exec("DROP DATABASE " + database + ";"); err != nil {
+			t.Errorf("Error dropping database %s: %+v\n", database, err)
+		}
+		if err := control.Close(); err != nil {
+			t.Errorf("Error closing control connection: %+v\n", err)
+		}
+	})
+	return db
+}
+
+func TestMigrateLegacyTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	migs := &migrate.AssetMigrationSource{
+		Asset:    migrations.Asset,
+		AssetDir: migrations.AssetDir,
+		Dir:      "sql",
+	}
+	_, err := migrate.ExecMax(db, "postgres", migs, migrate.Up, legacyMigrations)
+	if err != nil {
+		t.Fatalf("Error applying legacy migrations: %+v\n", err)
+	}
+
+	createdAt := time.Now().Add(-1 * time.Hour).Round(time.Millisecond)
+	legacy := []tokens.RefreshToken{
+		{ID: "legacy-1", CreatedAt: createdAt, CreatedFrom: "legacy", ProfileID: "profile-1", ClientID: "client", Scopes: []string{"a"}},
+		{ID: "legacy-2", CreatedAt: createdAt, CreatedFrom: "legacy", ProfileID: "profile-1", ClientID: "client", Scopes: []string{"b"}, Used: true},
+		{ID: "legacy-3", CreatedAt: createdAt, CreatedFrom: "legacy", ProfileID: "profile-2", ClientID: "client", Scopes: []string{"c"}, Revoked: true},
+	}
+	for _, token := range legacy {
+		_, err := db.Exec("INSERT INTO tokens (id, created_at, created_from, profile_id, client_id, revoked, used, scopes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			token.ID, token.CreatedAt, token.CreatedFrom, token.ProfileID, token.ClientID, token.Revoked, token.Used, "{"+token.Scopes[0]+"}")
+		if err != nil {
+			t.Fatalf("Error inserting legacy token %s: %+v\n", token.ID, err)
+		}
+	}
+
+	accountIDs := map[string]string{"profile-1": "account-1"}
+	updated, err := postgres.MigrateLegacyTokens(ctx, db, accountIDs)
+	if err != nil {
+		t.Fatalf("Error migrating legacy tokens: %+v\n", err)
+	}
+	if updated != 2 {
+		t.Errorf("Expected %d tokens to be updated, got %d", 2, updated)
+	}
+
+	// running it again should be a no-op
+	updated, err = postgres.MigrateLegacyTokens(ctx, db, accountIDs)
+	if err != nil {
+		t.Fatalf("Error re-running legacy token migration: %+v\n", err)
+	}
+	if updated != 0 {
+		t.Errorf("Expected %d tokens to be updated on the second run, got %d", 0, updated)
+	}
+
+	storer := postgres.NewStorer(ctx, db)
+	for _, token := range legacy {
+		expected := token
+		expected.AccountID = accountIDs[token.ProfileID]
+		result, err := storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Error retrieving migrated token %s: %+v\n", token.ID, err)
+		}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("Unexpected diff for %s (-wanted, +got): %s", token.ID, diff)
+		}
+	}
+}
+
+func TestMigrateFamilyID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	migs := &migrate.AssetMigrationSource{
+		Asset:    migrations.Asset,
+		AssetDir: migrations.AssetDir,
+		Dir:      "sql",
+	}
+	found, err := migs.FindMigrations()
+	if err != nil {
+		t.Fatalf("Error finding migrations: %+v\n", err)
+	}
+	before := -1
+	for pos, mig := range found {
+		if strings.HasSuffix(mig.Id, "_family_id.sql") {
+			before = pos
+			break
+		}
+	}
+	if before < 0 {
+		t.Fatal("Couldn't find the family_id migration")
+	}
+	_, err = migrate.ExecMax(db, "postgres", migs, migrate.Up, before)
+	if err != nil {
+		t.Fatalf("Error applying migrations before family_id: %+v\n", err)
+	}
+
+	legacy := tokens.RefreshToken{
+		ID:          "legacy-family",
+		CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+		CreatedFrom: "legacy",
+		ProfileID:   "profile",
+		ClientID:    "client",
+		AccountID:   "account",
+		Scopes:      []string{"a"},
+	}
+	_, err = db.Exec("INSERT INTO tokens (id, created_at, created_from, profile_id, client_id, account_id, revoked, used, scopes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		legacy.ID, legacy.CreatedAt, legacy.CreatedFrom, legacy.ProfileID, legacy.ClientID, legacy.AccountID, legacy.Revoked, legacy.Used, "{"+legacy.Scopes[0]+"}")
+	if err != nil {
+		t.Fatalf("Error inserting legacy token: %+v\n", err)
+	}
+	_, err = migrate.Exec(db, "postgres", migs, migrate.Up)
+	if err != nil {
+		t.Fatalf("Error applying remaining migrations: %+v\n", err)
+	}
+
+	storer := postgres.NewStorer(ctx, db)
+	result, err := storer.GetToken(ctx, legacy.ID)
+	if err != nil {
+		t.Fatalf("Error retrieving legacy token: %+v\n", err)
+	}
+	if result.FamilyID != legacy.ID {
+		t.Errorf("Expected legacy token to start its own family %q, got %q", legacy.ID, result.FamilyID)
+	}
+	revoked, err := storer.RevokeTokenFamily(ctx, legacy.ID)
+	if err != nil {
+		t.Fatalf("Error revoking legacy token's family: %+v\n", err)
+	}
+	if revoked != 1 {
+		t.Errorf("Expected %d token revoked, got %d", 1, revoked)
+	}
+}
+
+func TestMigrationsTableName(t *testing.T) {
+	t.Parallel()
+
+	defaults, err := postgres.Migrations(postgres.DefaultTableName)
+	if err != nil {
+		t.Fatalf("Error getting default migrations: %+v\n", err)
+	}
+	expected, err := defaults.FindMigrations()
+	if err != nil {
+		t.Fatalf("Error finding default migrations: %+v\n", err)
+	}
+
+	custom, err := postgres.Migrations("custom_tokens")
+	if err != nil {
+		t.Fatalf("Error getting custom migrations: %+v\n", err)
+	}
+	migs, err := custom.FindMigrations()
+	if err != nil {
+		t.Fatalf("Error finding custom migrations: %+v\n", err)
+	}
+	if len(migs) != len(expected) {
+		t.Fatalf("Expected %d migrations, got %d", len(expected), len(migs))
+	}
+	for pos, mig := range migs {
+		if !strings.HasPrefix(mig.Id, "custom_tokens_") {
+			t.Errorf("Expected migration %d ID to start with custom_tokens_, got %q", pos, mig.Id)
+		}
+		for _, stmt := range append(append([]string{}, mig.Up...), mig.Down...) {
+			if strings.Contains(stmt, "TABLE tokens") || strings.Contains(stmt, "ON tokens") || strings.Contains(stmt, "UPDATE tokens") || strings.Contains(stmt, " tokens_") {
+				t.Errorf("Expected migration %s to target custom_tokens, got %q", mig.Id, stmt)
+			}
+		}
+	}
+
+	_, err = postgres.Migrations("tokens; DROP TABLE tokens")
+	if !errors.Is(err, postgres.ErrInvalidTableName) {
+		t.Errorf("Expected postgres.ErrInvalidTableName, got %+v\n", err)
+	}
+}
+
+func TestStorersWithDifferentTables(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	storers := map[string]postgres.Storer{}
+	for _, table := range []string{postgres.DefaultTableName, "custom_tokens"} {
+		migs, err := postgres.Migrations(table)
+		if err != nil {
+			t.Fatalf("Error getting migrations for %s: %+v\n", table, err)
+		}
+		_, err = migrate.Exec(db, "postgres", migs, migrate.Up)
+		if err != nil {
+			t.Fatalf("Error applying migrations for %s: %+v\n", table, err)
+		}
+		storers[table] = postgres.NewStorer(ctx, db, postgres.WithTableName(table))
+	}
+
+	token := tokens.RefreshToken{
+		ID:          "custom-1",
+		CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		ClientID:    "client",
+		AccountID:   "account",
+		Scopes:      []string{"a"},
+	}
+	err := storers["custom_tokens"].CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	err = storers["custom_tokens"].CreateToken(ctx, token)
+	if !errors.Is(err, tokens.ErrTokenAlreadyExists) {
+		t.Errorf("Expected tokens.ErrTokenAlreadyExists, got %+v\n", err)
+	}
+
+	result, err := storers["custom_tokens"].GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Error retrieving token: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	_, err = storers[postgres.DefaultTableName].GetToken(ctx, token.ID)
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected tokens.ErrTokenNotFound from the default table, got %+v\n", err)
+	}
+}
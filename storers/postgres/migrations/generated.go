@@ -4,6 +4,10 @@
 // sql/tokens_20160522_hashing.sql
 // sql/tokens_20161126_jwt.sql
 // sql/tokens_20220226_account_id.sql
+// sql/tokens_20261014_created_metadata.sql
+// sql/tokens_20261015_reuse_attempts.sql
+// sql/tokens_20261016_family_id.sql
+// sql/tokens_20261017_history.sql
 // DO NOT EDIT!
 
 package migrations
@@ -151,6 +155,86 @@ func sqlTokens_20220226_account_idSql() (*asset, error) {
 	return a, nil
 }
 
+var _sqlTokens_20261014_created_metadataSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xd2\xd5\x55\xd0\xce\xcd\x4c\x2f\x4a\x2c\x49\x55\x08\x2d\xe0\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\xc9\xcf\x4e\xcd\x2b\x56\x70\x74\x71\x51\x70\xf6\xf7\x09\xf5\xf5\x53\x48\x2e\x4a\x4d\x2c\x49\x4d\x89\xcf\x2c\x50\x08\x73\x0c\x72\xf6\x70\x0c\xd2\x30\x31\xd5\xd4\xe1\xe2\xe4\x54\x50\xc0\xa6\xae\xb4\x38\xb5\x28\x3e\x31\x3d\x35\xaf\x44\x21\xc4\x35\x22\xc4\x9a\x8b\x0b\xd9\x3a\x97\xfc\xf2\x3c\x6c\x16\xba\x04\xf9\x07\xc0\x4c\xf2\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x46\xb2\x1b\x6a\x1f\x7e\x65\x08\xab\xad\xb9\x00\x03\x00\x5a\x6e\xb6\x45\xe4\x00\x00\x00")
+
+func sqlTokens_20261014_created_metadataSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_sqlTokens_20261014_created_metadataSql,
+		"sql/tokens_20261014_created_metadata.sql",
+	)
+}
+
+func sqlTokens_20261014_created_metadataSql() (*asset, error) {
+	bytes, err := sqlTokens_20261014_created_metadataSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "sql/tokens_20261014_created_metadata.sql", size: 228, mode: os.FileMode(436), modTime: time.Unix(1791978536, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _sqlTokens_20261015_reuse_attemptsSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\xcd\xbf\x0a\xc2\x30\x10\x07\xe0\x3d\x4f\xf1\xdb\xa5\xe0\xde\x29\x7a\x29\x04\xce\x44\xda\x0b\xb8\x49\x87\x43\x44\xfa\x87\xe6\xc4\xd7\x77\x12\x44\x7c\x81\xef\x6b\x1a\xec\xa6\xfb\x6d\x1b\x4d\x51\x56\xe7\x59\x42\x0f\xf1\x07\x0e\xb0\xe5\xa1\x73\x85\x27\xc2\x31\x73\x39\x25\x6c\xfa\xac\x7a\x1d\xcd\x74\x5a\xad\x22\x26\x41\xca\x82\x54\x98\x41\xa1\xf3\x85\x05\xfb\xd6\xb9\x6f\x94\x96\xd7\xfc\x8f\xa5\x3e\x9f\x3f\x6e\xec\x10\x2e\x71\x90\xe1\x67\x68\xdd\x7b\x00\x15\x48\x3b\x18\x9f\x00\x00\x00")
+
+func sqlTokens_20261015_reuse_attemptsSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_sqlTokens_20261015_reuse_attemptsSql,
+		"sql/tokens_20261015_reuse_attempts.sql",
+	)
+}
+
+func sqlTokens_20261015_reuse_attemptsSql() (*asset, error) {
+	bytes, err := sqlTokens_20261015_reuse_attemptsSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "sql/tokens_20261015_reuse_attempts.sql", size: 159, mode: os.FileMode(436), modTime: time.Unix(1791981644, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _sqlTokens_20261016_family_idSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\x8f\x41\x4b\x03\x31\x10\x46\xef\xf9\x15\xdf\xad\x2d\xd2\x9b\x78\x09\x1e\xc6\xcd\x94\x2e\xc4\xa4\x64\x27\xda\x5b\x58\x48\x2b\xc1\x6e\x2b\x5a\xd0\xfe\x7b\x51\xd6\x75\x0b\x7b\x9d\xe1\xcd\x9b\xb7\x5c\xe2\xa6\x2b\x2f\xef\xed\x79\x87\xf8\xa6\xc8\x0a\x07\x08\x3d\x58\xc6\xf9\xf4\xba\x3b\x7e\x80\x8c\x41\xe5\x6d\x7c\x74\xd8\xb7\x5d\x39\x5c\x52\xc9\x78\xa2\x50\xad\x29\xcc\xef\x6e\x17\x70\x5e\xe0\xa2\xb5\x30\xbc\xa2\x68\x05\xb3\x99\x56\x55\x60\x12\x46\xed\x0c\x6f\xfb\x4b\x69\xc0\x53\xc9\x5f\xf0\xee\xcf\x30\x1f\x16\x0b\xad\xe2\xc6\x90\x0c\xf2\x86\x65\x64\xbd\x47\xc9\x78\x5e\x73\xe0\xab\xe1\x8f\x4f\x8d\x43\xcc\xe9\xf3\xa8\x4c\xf0\x9b\xde\x5f\xaf\xc0\xdb\xba\x91\x66\xf2\x13\x3d\x55\xfd\x4b\xf7\xd9\xff\xf8\xbe\xed\xca\xe1\x92\x4a\xd6\xea\x7b\x00\xa2\x0e\x56\x3c\x39\x01\x00\x00")
+
+func sqlTokens_20261016_family_idSqlBytes() ([]byte, error) {
+	return bindataRead(
+		_sqlTokens_20261016_family_idSql,
+		"sql/tokens_20261016_family_id.sql",
+	)
+}
+
+func sqlTokens_20261016_family_idSql() (*asset, error) {
+	bytes, err := sqlTokens_20261016_family_idSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "sql/tokens_20261016_family_id.sql", size: 313, mode: os.FileMode(436), modTime: time.Unix(1791983354, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _sqlTokens_20261017_historySql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xd2\xd5\x55\xd0\xce\xcd\x4c\x2f\x4a\x2c\x49\x55\x08\x2d\xe0\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\xc9\xcf\x4e\xcd\x2b\x56\x70\x74\x71\x51\x70\xf6\xf7\x09\xf5\xf5\x53\x28\x2d\x4e\x4d\x89\x4f\x2c\x51\x08\xf1\xf4\x75\x0d\x0e\x71\xf4\x0d\x08\x89\x52\xf0\x0b\xf5\xf1\xb1\x26\xa0\xaf\x28\xb5\x2c\x3f\x1b\x97\x56\x2e\x64\x27\xb8\xe4\x97\xe7\x61\x33\xcc\x25\xc8\x3f\x00\x66\x9a\xa7\x9b\x82\x6b\x84\x67\x70\x48\x30\x92\xb9\xd6\xc4\x6b\x2a\x2d\x4e\x4d\x89\x4f\x2c\xb1\xe6\x02\x0c\x00\xfa\x0b\xbe\x7e\xfb\x00\x00\x00")
+
+func sqlTokens_20261017_historySqlBytes() ([]byte, error) {
+	return bindataRead(
+		_sqlTokens_20261017_historySql,
+		"sql/tokens_20261017_history.sql",
+	)
+}
+
+func sqlTokens_20261017_historySql() (*asset, error) {
+	bytes, err := sqlTokens_20261017_historySqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "sql/tokens_20261017_history.sql", size: 251, mode: os.FileMode(436), modTime: time.Unix(1791981644, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -203,10 +287,14 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
-	"sql/tokens_20160227_init.sql":       sqlTokens_20160227_initSql,
-	"sql/tokens_20160522_hashing.sql":    sqlTokens_20160522_hashingSql,
-	"sql/tokens_20161126_jwt.sql":        sqlTokens_20161126_jwtSql,
-	"sql/tokens_20220226_account_id.sql": sqlTokens_20220226_account_idSql,
+	"sql/tokens_20160227_init.sql":             sqlTokens_20160227_initSql,
+	"sql/tokens_20160522_hashing.sql":          sqlTokens_20160522_hashingSql,
+	"sql/tokens_20161126_jwt.sql":              sqlTokens_20161126_jwtSql,
+	"sql/tokens_20220226_account_id.sql":       sqlTokens_20220226_account_idSql,
+	"sql/tokens_20261014_created_metadata.sql": sqlTokens_20261014_created_metadataSql,
+	"sql/tokens_20261015_reuse_attempts.sql":   sqlTokens_20261015_reuse_attemptsSql,
+	"sql/tokens_20261016_family_id.sql":        sqlTokens_20261016_family_idSql,
+	"sql/tokens_20261017_history.sql":          sqlTokens_20261017_historySql,
 }
 
 // AssetDir returns the file names below a certain
@@ -251,10 +339,14 @@ type bintree struct {
 
 var _bintree = &bintree{nil, map[string]*bintree{
 	"sql": &bintree{nil, map[string]*bintree{
-		"tokens_20160227_init.sql":       &bintree{sqlTokens_20160227_initSql, map[string]*bintree{}},
-		"tokens_20160522_hashing.sql":    &bintree{sqlTokens_20160522_hashingSql, map[string]*bintree{}},
-		"tokens_20161126_jwt.sql":        &bintree{sqlTokens_20161126_jwtSql, map[string]*bintree{}},
-		"tokens_20220226_account_id.sql": &bintree{sqlTokens_20220226_account_idSql, map[string]*bintree{}},
+		"tokens_20160227_init.sql":             &bintree{sqlTokens_20160227_initSql, map[string]*bintree{}},
+		"tokens_20160522_hashing.sql":          &bintree{sqlTokens_20160522_hashingSql, map[string]*bintree{}},
+		"tokens_20161126_jwt.sql":              &bintree{sqlTokens_20161126_jwtSql, map[string]*bintree{}},
+		"tokens_20220226_account_id.sql":       &bintree{sqlTokens_20220226_account_idSql, map[string]*bintree{}},
+		"tokens_20261014_created_metadata.sql": &bintree{sqlTokens_20261014_created_metadataSql, map[string]*bintree{}},
+		"tokens_20261015_reuse_attempts.sql":   &bintree{sqlTokens_20261015_reuse_attemptsSql, map[string]*bintree{}},
+		"tokens_20261016_family_id.sql":        &bintree{sqlTokens_20261016_family_idSql, map[string]*bintree{}},
+		"tokens_20261017_history.sql":          &bintree{sqlTokens_20261017_historySql, map[string]*bintree{}},
 	}},
 }}
 
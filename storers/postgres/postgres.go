@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"darlinggo.co/pan"
@@ -13,6 +16,8 @@ import (
 	"lockbox.dev/tokens"
 )
 
+var _ tokens.TxStorer = Storer{}
+
 //go:generate go-bindata -pkg migrations -o migrations/generated.go sql/
 
 const (
@@ -21,21 +26,340 @@ const (
 	// against. Tests will run in their own isolated databases, not in the
 	// default database the connection string is for.
 	TestConnStringEnvVar = "PG_TEST_DB"
+
+	// DefaultTableName is the name of the table Storer keeps tokens in
+	// when WithTableName isn't used.
+	DefaultTableName = "tokens"
 )
 
 // Storer is an implementation of the Storer interface that is production quality
 // and backed by a PostgreSQL database.
 type Storer struct {
 	db *sql.DB
+
+	// tx is set when the Storer was created by WithTransaction, and all
+	// queries should be run against it instead of db.
+	tx *sql.Tx
+
+	// txDB is the database tx is running on. It's db, unless tx is a
+	// read on a replica.
+	txDB *sql.DB
+
+	// table is the name of the table tokens are stored in. If empty,
+	// DefaultTableName is used.
+	table string
+
+	// stmts caches the prepared statements for the queries run most
+	// often. If nil, every query is run unprepared.
+	stmts *stmtCache
+
+	// replicas are the read replicas of db that reads are spread across.
+	// If nil, reads are run against db.
+	replicas *replicaSet
+
+	// statementTimeout is how long PostgreSQL lets each statement the
+	// Storer runs take. If 0, the database's default is used.
+	statementTimeout time.Duration
+}
+
+// stmtCache holds prepared statements, keyed by the database they were
+// prepared on and their SQL. It is shared by copies of a Storer.
+type stmtCache struct {
+	lock  sync.Mutex
+	stmts map[stmtKey]*sql.Stmt
+}
+
+type stmtKey struct {
+	db    *sql.DB
+	query string
+}
+
+// replicaSet is a set of read replicas, used in turn. It is shared by
+// copies of a Storer.
+type replicaSet struct {
+	dbs  []*sql.DB
+	next uint64
+}
+
+func (r *replicaSet) pick() *sql.DB {
+	n := atomic.AddUint64(&r.next, 1)
+	return r.dbs[(n-1)%uint64(len(r.dbs))]
+}
+
+// Option configures a Storer when passed to NewStorer.
+type Option func(*Storer)
+
+// WithTableName has the Storer keep tokens in `table` instead of
+// DefaultTableName, so more than one Storer can share a database. The
+// table must already exist; see Migrations. `table` is used in queries
+// verbatim, and must be a valid, trusted PostgreSQL identifier.
+func WithTableName(table string) Option {
+	return func(s *Storer) {
+		s.table = table
+	}
+}
+
+// WithoutPreparedStatements has the Storer run every query unprepared,
+// for use with connection poolers that don't support prepared statements.
+func WithoutPreparedStatements() Option {
+	return func(s *Storer) {
+		s.stmts = nil
+	}
+}
+
+// WithReplicas has the Storer run its reads, GetTokens,
+// GetTokensByProfileID, ListTokensByProfileID, GetTokensByProfileIDs,
+// GetLatestToken, GetReuseAttempts, GetTokenHistory, and TokenStats,
+// against `replicas` in turn, instead of the primary database passed to
+// NewStorer. GetToken, which tokens.Dependencies.Validate relies on, all
+// writes, including UseToken, and everything run by WithTransaction
+// still use the primary. Replicas may lag behind the primary, so the
+// other reads may not see a token, or its latest changes, immediately
+// after it's written.
+func WithReplicas(replicas ...*sql.DB) Option {
+	return func(s *Storer) {
+		if len(replicas) < 1 {
+			s.replicas = nil
+			return
+		}
+		s.replicas = &replicaSet{dbs: replicas}
+	}
+}
+
+// WithStatementTimeout has PostgreSQL cancel any statement the Storer
+// runs that takes longer than `timeout`, bounding how long queries can
+// take even when the context they're run with has no deadline. The
+// timeout is set with SET LOCAL, so each operation run outside
+// WithTransaction is run in its own transaction, against the same
+// database it would otherwise use.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(s *Storer) {
+		s.statementTimeout = timeout
+	}
+}
+
+// querier is the subset of methods shared by *sql.DB and *sql.Tx that
+// Storer uses to run queries.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// NewStorer returns an instance of Storer that is ready to be used as a Storer,
+// configured by `opts`.
+func NewStorer(_ context.Context, db *sql.DB, opts ...Option) Storer {
+	storer := Storer{db: db, stmts: &stmtCache{stmts: map[stmtKey]*sql.Stmt{}}}
+	for _, opt := range opts {
+		opt(&storer)
+	}
+	return storer
+}
+
+func (s Storer) tableName() string {
+	if s.table == "" {
+		return DefaultTableName
+	}
+	return s.table
+}
+
+func (s Storer) conn() querier { //nolint:ireturn // returns whichever of db or tx is in use
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// readDB returns the database reads should be run against when the
+// Storer wasn't created by WithTransaction.
+func (s Storer) readDB() *sql.DB {
+	if s.replicas == nil {
+		return s.db
+	}
+	return s.replicas.pick()
+}
+
+// readConn is like conn, but for reads.
+func (s Storer) readConn() querier { //nolint:ireturn // returns whichever of a replica, db, or tx is in use
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.readDB()
+}
+
+// prepared returns a statement for `query` prepared on `db`, preparing
+// it the first time it's requested and reusing it after that. If it's
+// prepared by more than one caller at once, the first one cached is kept
+// and the rest are closed. If the
+// Storer was created by WithTransaction, the statement runs in that
+// transaction. If the Storer isn't caching prepared statements, nil is
+// returned.
+func (s Storer) prepared(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	if s.stmts == nil {
+		return nil, nil //nolint:nilnil // a nil statement means don't prepare
+	}
+	if s.tx != nil {
+		// statements used in a transaction need to be prepared on the
+		// database the transaction is running on
+		db = s.txDB
+	}
+	key := stmtKey{db: db, query: query}
+	s.stmts.lock.Lock()
+	stmt, ok := s.stmts.stmts[key]
+	s.stmts.lock.Unlock()
+	if !ok {
+		// prepare without holding the lock, so a slow prepare doesn't
+		// hold up queries that already have their statements
+		prepared, err := db.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		s.stmts.lock.Lock()
+		stmt, ok = s.stmts.stmts[key]
+		if !ok {
+			stmt = prepared
+			s.stmts.stmts[key] = stmt
+		}
+		s.stmts.lock.Unlock()
+		if ok {
+			// another caller prepared it first; use theirs
+			if err := prepared.Close(); err != nil {
+				yall.FromContext(ctx).WithError(err).Debug("error closing duplicate prepared statement")
+			}
+		}
+	}
+	if s.tx != nil {
+		return s.tx.StmtContext(ctx, stmt), nil
+	}
+	return stmt, nil
 }
 
-// NewStorer returns an instance of Storer that is ready to be used as a Storer.
-func NewStorer(_ context.Context, db *sql.DB) Storer {
-	return Storer{db: db}
+// queryPrepared runs `query` like Query against `db`, as a prepared
+// statement if the Storer is caching them.
+func (s Storer) queryPrepared(ctx context.Context, db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := s.prepared(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt != nil {
+		return stmt.Query(args...) //nolint:sqlclosecheck // the caller closes the rows
+	}
+	if s.tx != nil {
+		return s.tx.Query(query, args...) //nolint:sqlclosecheck // the caller closes the rows
+	}
+	return db.Query(query, args...) //nolint:sqlclosecheck // the caller closes the rows
+}
+
+// execPrepared runs `query` like Exec against the primary database, as a
+// prepared statement if the Storer is caching them.
+func (s Storer) execPrepared(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := s.prepared(ctx, s.db, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return s.conn().Exec(query, args...)
+	}
+	return stmt.Exec(args...)
+}
+
+// queryRowPrepared runs `query` like QueryRow against the primary
+// database and scans the result into `dest`, as a prepared statement if
+// the Storer is caching them.
+func (s Storer) queryRowPrepared(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	stmt, err := s.prepared(ctx, s.db, query)
+	if err != nil {
+		return err
+	}
+	if stmt == nil {
+		return s.conn().QueryRow(query, args...).Scan(dest...)
+	}
+	return stmt.QueryRow(args...).Scan(dest...)
 }
 
-func getTokenSQL(_ context.Context, token string) *pan.Query {
-	var t RefreshToken
+// Close closes the prepared statements the Storer has cached, then the
+// database connection pools passed to NewStorer and WithReplicas. The
+// Storer can't be used afterwards; its operations will return errors.
+// Close shouldn't be called if those connection pools are shared with
+// anything else, as closing them closes them for everything using them.
+func (s Storer) Close() error {
+	var res error
+	if s.stmts != nil {
+		s.stmts.lock.Lock()
+		for key, stmt := range s.stmts.stmts {
+			if err := stmt.Close(); err != nil && res == nil {
+				res = err
+			}
+			delete(s.stmts.stmts, key)
+		}
+		s.stmts.lock.Unlock()
+	}
+	if err := s.db.Close(); err != nil && res == nil {
+		res = err
+	}
+	if s.replicas != nil {
+		for _, replica := range s.replicas.dbs {
+			if err := replica.Close(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+// WithTransaction calls `fn` with a Storer whose queries all run in a
+// single PostgreSQL transaction. If `fn` returns an error, the
+// transaction is rolled back and the error is returned; otherwise, the
+// transaction is committed.
+func (s Storer) WithTransaction(ctx context.Context, fn func(tx tokens.Storer) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return s.inTx(ctx, s.db, func(tx Storer) error {
+		return fn(tx)
+	})
+}
+
+// inTx calls `fn` with a copy of the Storer whose queries all run in a
+// single transaction on `db`, with the statement timeout set if the
+// Storer has one. The transaction is committed unless `fn` returns an
+// error.
+func (s Storer) inTx(ctx context.Context, db *sql.DB, fn func(tx Storer) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	txStorer := s
+	txStorer.tx = tx
+	txStorer.txDB = db
+	if s.statementTimeout > 0 {
+		timeout := s.statementTimeout.Milliseconds()
+		if timeout < 1 {
+			timeout = 1
+		}
+		_, err = tx.Exec("SET LOCAL statement_timeout = " + strconv.FormatInt(timeout, 10))
+	}
+	if err == nil {
+		err = fn(txStorer)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			yall.FromContext(ctx).WithError(rbErr).Error("failed to roll back transaction")
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// needsTimeoutTx returns true if the Storer has a statement timeout that
+// needs a transaction to be set in, because it isn't already running in
+// one.
+func (s Storer) needsTimeoutTx() bool {
+	return s.statementTimeout > 0 && s.tx == nil
+}
+
+func getTokenSQL(_ context.Context, table, token string) *pan.Query {
+	t := RefreshToken{table: table}
 	query := pan.New("SELECT " + pan.Columns(t).String() + " FROM " + pan.Table(t))
 	query.Where()
 	query.Comparison(t, "ID", "=", token)
@@ -43,14 +367,25 @@ func getTokenSQL(_ context.Context, token string) *pan.Query {
 }
 
 // GetToken retrieves the tokens.RefreshToken with an ID matching `token` from Storer. If no
-// tokens.RefreshToken has that ID, an ErrTokenNotFound error is returned.
+// tokens.RefreshToken has that ID, an ErrTokenNotFound error is returned. It always reads from
+// the primary database, even when the Storer has replicas, so tokens.Dependencies.Validate sees
+// tokens.RefreshTokens that were just used or revoked.
 func (s Storer) GetToken(ctx context.Context, token string) (tokens.RefreshToken, error) {
-	query := getTokenSQL(ctx, token)
+	if s.needsTimeoutTx() {
+		var res tokens.RefreshToken
+		err := s.inTx(ctx, s.db, func(tx Storer) error {
+			var err error
+			res, err = tx.GetToken(ctx, token)
+			return err
+		})
+		return res, err
+	}
+	query := getTokenSQL(ctx, s.tableName(), token)
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
 		return tokens.RefreshToken{}, err
 	}
-	rows, err := s.db.Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	rows, err := s.queryPrepared(ctx, s.db, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
 	if err != nil {
 		return tokens.RefreshToken{}, err
 	}
@@ -68,43 +403,201 @@ func (s Storer) GetToken(ctx context.Context, token string) (tokens.RefreshToken
 		return tokens.RefreshToken{}, err
 	}
 	if !found {
-		return tokens.RefreshToken{}, tokens.ErrTokenNotFound
+		return tokens.RefreshToken{}, tokens.TokenNotFoundError{ID: token}
 	}
 	return fromPostgres(res), nil
 }
 
-func createTokenSQL(token tokens.RefreshToken) *pan.Query {
-	query := pan.Insert(toPostgres(token))
+func getTokensSQL(_ context.Context, table string, ids []string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("SELECT " + pan.Columns(t).String() + " FROM " + pan.Table(t))
+	query.Where()
+	query.Expression(pan.Column(t, "ID")+" = ANY(?)", pq.Array(ids))
+	return query.Flush(" ")
+}
+
+// GetTokens retrieves the tokens.RefreshTokens with IDs matching `ids` from Storer, keyed by their
+// IDs. IDs that no tokens.RefreshToken has are left out of the result.
+func (s Storer) GetTokens(ctx context.Context, ids []string) (map[string]tokens.RefreshToken, error) {
+	if s.needsTimeoutTx() {
+		var res map[string]tokens.RefreshToken
+		err := s.inTx(ctx, s.readDB(), func(tx Storer) error {
+			var err error
+			res, err = tx.GetTokens(ctx, ids)
+			return err
+		})
+		return res, err
+	}
+	res := make(map[string]tokens.RefreshToken, len(ids))
+	if len(ids) < 1 {
+		return res, nil
+	}
+	query := getTokensSQL(ctx, s.tableName(), ids)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.readConn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(ctx, rows)
+	for rows.Next() {
+		var token RefreshToken
+		err = pan.Unmarshal(rows, &token)
+		if err != nil {
+			return nil, err
+		}
+		res[token.ID] = fromPostgres(token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func createTokenSQL(table string, token tokens.RefreshToken) *pan.Query {
+	t := toPostgres(token)
+	t.table = table
+	query := pan.Insert(t)
 	return query.Flush(" ")
 }
 
 // CreateToken inserts the passed tokens.RefreshToken into Storer. If a tokens.RefreshToken
 // with the same ID already exists in Storer, an ErrTokenAlreadyExists error
 // will be returned, and the tokens.RefreshToken will not be inserted.
-func (s Storer) CreateToken(_ context.Context, token tokens.RefreshToken) error {
-	query := createTokenSQL(token)
+func (s Storer) CreateToken(ctx context.Context, token tokens.RefreshToken) error {
+	if s.needsTimeoutTx() {
+		return s.inTx(ctx, s.db, func(tx Storer) error {
+			return tx.CreateToken(ctx, token)
+		})
+	}
+	query := createTokenSQL(s.tableName(), token)
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
 		return err
 	}
-	_, err = s.db.Exec(queryStr, query.Args()...)
+	_, err = s.conn().Exec(queryStr, query.Args()...)
 	var pqErr *pq.Error
-	if errors.As(err, &pqErr) && pqErr.Constraint == "tokens_pkey" {
+	if errors.As(err, &pqErr) && pqErr.Constraint == s.tableName()+"_pkey" {
 		err = tokens.ErrTokenAlreadyExists
 	}
 	return err
 }
 
-func updateTokensSQL(_ context.Context, change tokens.RefreshTokenChange) *pan.Query {
-	var token RefreshToken
+func createOrGetTokenSQL(table string, token tokens.RefreshToken) *pan.Query {
+	t := toPostgres(token)
+	t.table = table
+	query := pan.Insert(t)
+	query.Expression("ON CONFLICT (" + pan.Column(t, "ID") + ") DO NOTHING")
+	query.Expression("RETURNING " + pan.Columns(t).String())
+	return query.Flush(" ")
+}
+
+// CreateOrGetToken inserts the passed tokens.RefreshToken into Storer, unless a
+// tokens.RefreshToken with the same ID already exists. The stored tokens.RefreshToken is
+// returned, along with whether it was just created.
+func (s Storer) CreateOrGetToken(ctx context.Context, token tokens.RefreshToken) (tokens.RefreshToken, bool, error) {
+	if s.needsTimeoutTx() {
+		var res tokens.RefreshToken
+		var created bool
+		err := s.inTx(ctx, s.db, func(tx Storer) error {
+			var err error
+			res, created, err = tx.CreateOrGetToken(ctx, token)
+			return err
+		})
+		return res, created, err
+	}
+	query := createOrGetTokenSQL(s.tableName(), token)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	rows, err := s.conn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	defer closeRows(ctx, rows)
+	var res RefreshToken
+	var created bool
+	for rows.Next() {
+		err = pan.Unmarshal(rows, &res)
+		if err != nil {
+			return tokens.RefreshToken{}, false, err
+		}
+		created = true
+	}
+	if err = rows.Err(); err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	if created {
+		return fromPostgres(res), true, nil
+	}
+
+	// the token already existed, so look it up. This goes to the primary,
+	// not a replica, because the existing token may have only just been
+	// written.
+	query = getTokenSQL(ctx, s.tableName(), token.ID)
+	queryStr, err = query.PostgreSQLString()
+	if err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	existing, err := s.conn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	defer closeRows(ctx, existing)
+	var found bool
+	for existing.Next() {
+		err = pan.Unmarshal(existing, &res)
+		if err != nil {
+			return tokens.RefreshToken{}, false, err
+		}
+		found = true
+	}
+	if err = existing.Err(); err != nil {
+		return tokens.RefreshToken{}, false, err
+	}
+	if !found {
+		return tokens.RefreshToken{}, false, tokens.TokenNotFoundError{ID: token.ID}
+	}
+	return fromPostgres(res), false, nil
+}
+
+func updateTokensSQL(_ context.Context, table string, change tokens.RefreshTokenChange) *pan.Query {
+	token := RefreshToken{table: table}
 	query := pan.New("UPDATE " + pan.Table(token) + " SET ")
+	updateTokensSet(query, token, change)
+	query.Where()
+	updateTokensFilter(query, token, change)
+	query.Flush(" AND ")
+	query.Expression("RETURNING " + pan.Column(token, "ID"))
+	return query.Flush(" ")
+}
+
+// updateTokensSet adds the assignments that apply `change` to `query`.
+func updateTokensSet(query *pan.Query, token RefreshToken, change tokens.RefreshTokenChange) {
 	if change.Revoked != nil {
 		query.Comparison(token, "Revoked", "=", change.Revoked)
+		query.Expression(revokedAtColumn + " = " + eventTimeSQL(pan.Column(token, "Revoked"), revokedAtColumn, *change.Revoked))
 	}
 	if change.Used != nil {
 		query.Comparison(token, "Used", "=", change.Used)
+		query.Expression(usedAtColumn + " = " + eventTimeSQL(pan.Column(token, "Used"), usedAtColumn, *change.Used))
 	}
-	query.Flush(", ").Where()
+	if change.Scopes != nil {
+		query.Comparison(token, "Scopes", "=", toPostgres(tokens.RefreshToken{Scopes: *change.Scopes}).Scopes)
+	}
+	if change.CreatedAt != nil {
+		query.Comparison(token, "CreatedAt", "=", *change.CreatedAt)
+	}
+	query.Flush(", ")
+}
+
+// updateTokensFilter adds the comparisons that match the ID, ClientID,
+// ProfileID, and AccountID constraints of `change` to `query`, leaving
+// them to be flushed by the caller.
+func updateTokensFilter(query *pan.Query, token RefreshToken, change tokens.RefreshTokenChange) {
 	if change.ID != "" {
 		query.Comparison(token, "ID", "=", change.ID)
 	}
@@ -117,39 +610,161 @@ func updateTokensSQL(_ context.Context, change tokens.RefreshTokenChange) *pan.Q
 	if change.AccountID != "" {
 		query.Comparison(token, "AccountID", "=", change.AccountID)
 	}
-	return query.Flush(" AND ")
 }
 
 // UpdateTokens applies `change` to all the tokens.RefreshTokens in Storer that match the ID,
-// ProfileID, or ClientID constraints of `change`.
-func (s Storer) UpdateTokens(ctx context.Context, change tokens.RefreshTokenChange) error {
+// ProfileID, ClientID, or AccountID constraints of `change`, returning the IDs of the
+// tokens.RefreshTokens that matched.
+func (s Storer) UpdateTokens(ctx context.Context, change tokens.RefreshTokenChange) ([]string, error) {
+	if s.needsTimeoutTx() {
+		var ids []string
+		err := s.inTx(ctx, s.db, func(tx Storer) error {
+			var err error
+			ids, err = tx.UpdateTokens(ctx, change)
+			return err
+		})
+		return ids, err
+	}
 	if change.IsEmpty() {
-		return nil
+		return nil, nil
 	}
 	if !change.HasFilter() {
-		return tokens.ErrNoTokenChangeFilter
+		return nil, tokens.ErrNoTokenChangeFilter
 	}
-	query := updateTokensSQL(ctx, change)
+	query := updateTokensSQL(ctx, s.tableName(), change)
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = s.db.Exec(queryStr, query.Args()...)
-	return err
+	rows, err := s.conn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(ctx, rows)
+	var ids []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
-func useTokenSQL(_ context.Context, id string) *pan.Query {
-	var t RefreshToken
+func updateTokensBatchSQL(_ context.Context, table string, change tokens.RefreshTokenChange, after string, batchSize int) *pan.Query {
+	token := RefreshToken{table: table}
+	id := pan.Column(token, "ID")
+	query := pan.New("WITH batch AS (SELECT " + id + " FROM " + pan.Table(token))
+	query.Where()
+	updateTokensFilter(query, token, change)
+	if after != "" {
+		query.Comparison(token, "ID", ">", after)
+	}
+	query.Flush(" AND ")
+	query.Expression("ORDER BY "+id+" LIMIT ?), updated AS (UPDATE "+pan.Table(token)+" SET", batchSize)
+	query.Flush(" ")
+	updateTokensSet(query, token, change)
+	query.Expression("WHERE " + id + " IN (SELECT " + id + " FROM batch) RETURNING " + id + ")")
+	query.Expression("SELECT COUNT(*), MAX(" + id + ") FROM updated")
+	return query.Flush(" ")
+}
+
+// UpdateTokensBatched applies `change` to all the tokens.RefreshTokens in Storer that match the
+// ID, ProfileID, ClientID, or AccountID constraints of `change`, `batchSize` at a time in order
+// of their IDs, with each batch its own statement so the row locks it takes are released before
+// the next one starts. The number of tokens.RefreshTokens that matched is returned. If
+// `batchSize` is less than 1, tokens.DefaultUpdateBatchSize is used. If the Storer was created
+// by WithTransaction, all the batches are part of that transaction, and the locks are held until
+// it ends.
+func (s Storer) UpdateTokensBatched(ctx context.Context, change tokens.RefreshTokenChange, batchSize int) (int, error) {
+	if change.IsEmpty() {
+		return 0, nil
+	}
+	if !change.HasFilter() {
+		return 0, tokens.ErrNoTokenChangeFilter
+	}
+	if batchSize < 1 {
+		batchSize = tokens.DefaultUpdateBatchSize
+	}
+	var total int
+	var after string
+	for {
+		var count int
+		var err error
+		if s.needsTimeoutTx() {
+			err = s.inTx(ctx, s.db, func(tx Storer) error {
+				var err error
+				count, after, err = tx.updateTokensBatch(ctx, change, after, batchSize)
+				return err
+			})
+		} else {
+			count, after, err = s.updateTokensBatch(ctx, change, after, batchSize)
+		}
+		if err != nil {
+			return total, err
+		}
+		total += count
+		if count < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// updateTokensBatch applies `change` to up to `batchSize` of the matching
+// tokens.RefreshTokens with IDs after `after`, returning how many it
+// changed and the last of their IDs.
+func (s Storer) updateTokensBatch(ctx context.Context, change tokens.RefreshTokenChange, after string, batchSize int) (int, string, error) {
+	query := updateTokensBatchSQL(ctx, s.tableName(), change, after, batchSize)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, "", err
+	}
+	var count int
+	var last sql.NullString
+	err = s.conn().QueryRow(queryStr, query.Args()...).Scan(&count, &last)
+	if err != nil {
+		return 0, "", err
+	}
+	return count, last.String, nil
+}
+
+// usedAtColumn and revokedAtColumn are the columns recording when a token
+// was used and revoked, for GetTokenHistory. They're NULL for tokens that
+// haven't been, or that were before the columns were added. They aren't
+// part of RefreshToken, because they're only read by GetTokenHistory.
+const (
+	usedAtColumn    = "used_at"
+	revokedAtColumn = "revoked_at"
+)
+
+// eventTimeSQL returns the expression to set `timeColumn` to when setting
+// the boolean `stateColumn` to `state`: now if it's becoming true, its
+// current value if it already was, and NULL if it's becoming false.
+func eventTimeSQL(stateColumn, timeColumn string, state bool) string {
+	if !state {
+		return "NULL"
+	}
+	return "CASE WHEN " + stateColumn + " THEN " + timeColumn + " ELSE NOW() END"
+}
+
+func useTokenSQL(_ context.Context, table, id string) *pan.Query {
+	t := RefreshToken{table: table}
 	query := pan.New("UPDATE " + pan.Table(t) + " SET ")
 	query.Comparison(t, "Used", "=", true)
-	query.Flush(" ").Where()
+	query.Expression(usedAtColumn + " = NOW()")
+	query.Flush(", ").Where()
 	query.Comparison(t, "ID", "=", id)
 	query.Comparison(t, "Used", "=", false)
 	return query.Flush(" AND ")
 }
 
-func useTokenExistsSQL(_ context.Context, id string) *pan.Query {
-	var t RefreshToken
+func useTokenExistsSQL(_ context.Context, table, id string) *pan.Query {
+	t := RefreshToken{table: table}
 	query := pan.New("SELECT COUNT(*) FROM " + pan.Table(t))
 	query.Where()
 	query.Comparison(t, "ID", "=", id)
@@ -161,12 +776,17 @@ func useTokenExistsSQL(_ context.Context, id string) *pan.Query {
 // tokens.ErrTokenUsed if the token has already been marked used, or a
 // tokens.ErrTokenNotFound if the token doesn't exist in Storer.
 func (s Storer) UseToken(ctx context.Context, id string) error {
-	query := useTokenSQL(ctx, id)
+	if s.needsTimeoutTx() {
+		return s.inTx(ctx, s.db, func(tx Storer) error {
+			return tx.UseToken(ctx, id)
+		})
+	}
+	query := useTokenSQL(ctx, s.tableName(), id)
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
 		return err
 	}
-	rows, err := s.db.Exec(queryStr, query.Args()...)
+	rows, err := s.execPrepared(ctx, queryStr, query.Args()...)
 	if err != nil {
 		return err
 	}
@@ -177,23 +797,370 @@ func (s Storer) UseToken(ctx context.Context, id string) error {
 	if results >= 1 {
 		return nil
 	}
-	query = useTokenExistsSQL(ctx, id)
+	query = useTokenExistsSQL(ctx, s.tableName(), id)
 	queryStr, err = query.PostgreSQLString()
 	if err != nil {
 		return err
 	}
-	err = s.db.QueryRow(queryStr, query.Args()...).Scan(&results)
+	err = s.queryRowPrepared(ctx, queryStr, query.Args(), &results)
 	if err != nil {
 		return err
 	}
 	if results >= 1 {
-		return tokens.ErrTokenUsed
+		return tokens.TokenUsedError{ID: id}
 	}
-	return tokens.ErrTokenNotFound
+	return tokens.TokenNotFoundError{ID: id}
+}
+
+func useAndGetTokenSQL(_ context.Context, table, id string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("UPDATE " + pan.Table(t) + " SET ")
+	query.Comparison(t, "Used", "=", true)
+	query.Expression(usedAtColumn + " = NOW()")
+	query.Flush(", ").Where()
+	query.Comparison(t, "ID", "=", id)
+	query.Comparison(t, "Revoked", "=", false)
+	query.Comparison(t, "Used", "=", false)
+	query.Flush(" AND ")
+	query.Expression("RETURNING " + pan.Columns(t).String())
+	return query.Flush(" ")
 }
 
-func getTokensByProfileIDSQL(_ context.Context, profileID string, since, before time.Time) *pan.Query {
-	var token RefreshToken
+// UseAndGetToken atomically marks the token specified by `id` as used, returning it as it was
+// before it was used. A tokens.ErrTokenRevoked or tokens.ErrTokenUsed is returned if the token has
+// been revoked or used, and a tokens.ErrTokenNotFound if it doesn't exist in Storer.
+func (s Storer) UseAndGetToken(ctx context.Context, id string) (tokens.RefreshToken, error) {
+	if s.needsTimeoutTx() {
+		var res tokens.RefreshToken
+		err := s.inTx(ctx, s.db, func(tx Storer) error {
+			var err error
+			res, err = tx.UseAndGetToken(ctx, id)
+			return err
+		})
+		return res, err
+	}
+	query := useAndGetTokenSQL(ctx, s.tableName(), id)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	rows, err := s.conn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	defer closeRows(ctx, rows)
+	var res RefreshToken
+	var found bool
+	for rows.Next() {
+		err = pan.Unmarshal(rows, &res)
+		if err != nil {
+			return tokens.RefreshToken{}, err
+		}
+		found = true
+	}
+	if err = rows.Err(); err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	if found {
+		// RETURNING gives us the token after it was used
+		res.Used = false
+		return fromPostgres(res), nil
+	}
+	return tokens.RefreshToken{}, s.tokenStateErr(ctx, id)
+}
+
+func touchTokenSQL(_ context.Context, table, id string, now time.Time) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("UPDATE " + pan.Table(t) + " SET ")
+	query.Comparison(t, "CreatedAt", "=", now)
+	query.Flush(" ").Where()
+	query.Comparison(t, "ID", "=", id)
+	query.Comparison(t, "Revoked", "=", false)
+	query.Comparison(t, "Used", "=", false)
+	return query.Flush(" AND ")
+}
+
+func tokenStateSQL(_ context.Context, table, id string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("SELECT " + pan.Column(t, "Revoked") + ", " + pan.Column(t, "Used") + " FROM " + pan.Table(t))
+	query.Where()
+	query.Comparison(t, "ID", "=", id)
+	return query.Flush(" ")
+}
+
+// TouchToken atomically sets the CreatedAt of the token specified by `id` to now, returning a
+// tokens.ErrTokenRevoked or tokens.ErrTokenUsed if the token has been revoked or used, or a
+// tokens.ErrTokenNotFound if the token doesn't exist in Storer.
+func (s Storer) TouchToken(ctx context.Context, id string) error {
+	if s.needsTimeoutTx() {
+		return s.inTx(ctx, s.db, func(tx Storer) error {
+			return tx.TouchToken(ctx, id)
+		})
+	}
+	query := touchTokenSQL(ctx, s.tableName(), id, time.Now())
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return err
+	}
+	res, err := s.conn().Exec(queryStr, query.Args()...)
+	if err != nil {
+		return err
+	}
+	touched, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if touched >= 1 {
+		return nil
+	}
+	return s.tokenStateErr(ctx, id)
+}
+
+// tokenStateErr explains why an update to the live token specified by
+// `id` didn't match it, returning tokens.ErrTokenRevoked,
+// tokens.ErrTokenUsed, or tokens.ErrTokenNotFound.
+func (s Storer) tokenStateErr(ctx context.Context, id string) error {
+	query := tokenStateSQL(ctx, s.tableName(), id)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return err
+	}
+	var revoked, used bool
+	err = s.conn().QueryRow(queryStr, query.Args()...).Scan(&revoked, &used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tokens.TokenNotFoundError{ID: id}
+	}
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return tokens.TokenRevokedError{ID: id}
+	}
+	if used {
+		return tokens.TokenUsedError{ID: id}
+	}
+	// the token changed between the two queries, and wasn't updated
+	return tokens.TokenNotFoundError{ID: id}
+}
+
+func revokeTokensSQL(_ context.Context, table string, ids []string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("UPDATE " + pan.Table(t) + " SET ")
+	query.Comparison(t, "Revoked", "=", true)
+	query.Expression(revokedAtColumn + " = NOW()")
+	query.Flush(", ").Where()
+	query.Expression(pan.Column(t, "ID")+" = ANY(?)", pq.Array(ids))
+	query.Comparison(t, "Revoked", "=", false)
+	return query.Flush(" AND ")
+}
+
+// RevokeTokens marks the tokens.RefreshTokens with IDs matching `ids` as revoked, returning how
+// many were revoked. tokens.RefreshTokens that were already revoked or don't exist aren't counted.
+func (s Storer) RevokeTokens(ctx context.Context, ids []string) (int, error) {
+	if s.needsTimeoutTx() {
+		var revoked int
+		err := s.inTx(ctx, s.db, func(tx Storer) error {
+			var err error
+			revoked, err = tx.RevokeTokens(ctx, ids)
+			return err
+		})
+		return revoked, err
+	}
+	if len(ids) < 1 {
+		return 0, nil
+	}
+	query := revokeTokensSQL(ctx, s.tableName(), ids)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.conn().Exec(queryStr, query.Args()...)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(revoked), nil
+}
+
+func revokeTokenFamilySQL(_ context.Context, table, familyID string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("UPDATE " + pan.Table(t) + " SET ")
+	query.Comparison(t, "Revoked", "=", true)
+	query.Expression(revokedAtColumn + " = NOW()")
+	query.Flush(", ").Where()
+	query.Comparison(t, "FamilyID", "=", familyID)
+	query.Comparison(t, "Revoked", "=", false)
+	return query.Flush(" AND ")
+}
+
+// RevokeTokenFamily marks all the tokens.RefreshTokens with a FamilyID matching `familyID` as
+// revoked, returning how many were revoked. tokens.RefreshTokens that were already revoked aren't
+// counted. An empty `familyID` matches no tokens.RefreshTokens.
+func (s Storer) RevokeTokenFamily(ctx context.Context, familyID string) (int, error) {
+	if s.needsTimeoutTx() {
+		var revoked int
+		err := s.inTx(ctx, s.db, func(tx Storer) error {
+			var err error
+			revoked, err = tx.RevokeTokenFamily(ctx, familyID)
+			return err
+		})
+		return revoked, err
+	}
+	if familyID == "" {
+		return 0, nil
+	}
+	query := revokeTokenFamilySQL(ctx, s.tableName(), familyID)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.conn().Exec(queryStr, query.Args()...)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(revoked), nil
+}
+
+// reuseAttemptsColumn is the column recording how many times a used token
+// has been presented again. It isn't part of RefreshToken, because it's
+// only read and written by the reuse attempt methods.
+const reuseAttemptsColumn = "reuse_attempts"
+
+func markTokenReuseAttemptSQL(_ context.Context, table, id string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("UPDATE " + pan.Table(t) + " SET " + reuseAttemptsColumn + " = " + reuseAttemptsColumn + " + 1")
+	query.Where()
+	query.Comparison(t, "ID", "=", id)
+	query.Expression("RETURNING " + reuseAttemptsColumn)
+	return query.Flush(" ")
+}
+
+func getReuseAttemptsSQL(_ context.Context, table, id string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("SELECT " + reuseAttemptsColumn + " FROM " + pan.Table(t))
+	query.Where()
+	query.Comparison(t, "ID", "=", id)
+	return query.Flush(" ")
+}
+
+// MarkTokenReuseAttempt records that the tokens.RefreshToken specified by `id` was presented
+// again after being used, returning the number of times that has happened. If the
+// tokens.RefreshToken doesn't exist in Storer, a tokens.ErrTokenNotFound error is returned.
+func (s Storer) MarkTokenReuseAttempt(ctx context.Context, id string) (int, error) {
+	if s.needsTimeoutTx() {
+		var attempts int
+		err := s.inTx(ctx, s.db, func(tx Storer) error {
+			var err error
+			attempts, err = tx.MarkTokenReuseAttempt(ctx, id)
+			return err
+		})
+		return attempts, err
+	}
+	query := markTokenReuseAttemptSQL(ctx, s.tableName(), id)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, err
+	}
+	var attempts int
+	err = s.conn().QueryRow(queryStr, query.Args()...).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, tokens.TokenNotFoundError{ID: id}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// GetReuseAttempts returns the number of times the tokens.RefreshToken specified by `id` has
+// been presented again after being used. If the tokens.RefreshToken doesn't exist in Storer, a
+// tokens.ErrTokenNotFound error is returned.
+func (s Storer) GetReuseAttempts(ctx context.Context, id string) (int, error) {
+	if s.needsTimeoutTx() {
+		var attempts int
+		err := s.inTx(ctx, s.readDB(), func(tx Storer) error {
+			var err error
+			attempts, err = tx.GetReuseAttempts(ctx, id)
+			return err
+		})
+		return attempts, err
+	}
+	query := getReuseAttemptsSQL(ctx, s.tableName(), id)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, err
+	}
+	var attempts int
+	err = s.readConn().QueryRow(queryStr, query.Args()...).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, tokens.TokenNotFoundError{ID: id}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+func getTokenHistorySQL(_ context.Context, table, id string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("SELECT " + pan.Columns(t).String() + ", " + usedAtColumn + ", " + revokedAtColumn + " FROM " + pan.Table(t))
+	query.Where()
+	query.Comparison(t, "ID", "=", id)
+	return query.Flush(" ")
+}
+
+// GetTokenHistory returns the tokens.RefreshToken specified by `id` along with when it was
+// created, used, and revoked. If the tokens.RefreshToken doesn't exist in Storer, a
+// tokens.ErrTokenNotFound error is returned. Tokens used or revoked before Storer recorded when
+// have events with zero times.
+func (s Storer) GetTokenHistory(ctx context.Context, id string) (tokens.TokenHistory, error) {
+	if s.needsTimeoutTx() {
+		var history tokens.TokenHistory
+		err := s.inTx(ctx, s.readDB(), func(tx Storer) error {
+			var err error
+			history, err = tx.GetTokenHistory(ctx, id)
+			return err
+		})
+		return history, err
+	}
+	query := getTokenHistorySQL(ctx, s.tableName(), id)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return tokens.TokenHistory{}, err
+	}
+	rows, err := s.readConn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return tokens.TokenHistory{}, err
+	}
+	defer closeRows(ctx, rows)
+	var res RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	var found bool
+	for rows.Next() {
+		err = pan.Unmarshal(rows, &res, &usedAt, &revokedAt)
+		if err != nil {
+			return tokens.TokenHistory{}, err
+		}
+		found = true
+	}
+	if err = rows.Err(); err != nil {
+		return tokens.TokenHistory{}, err
+	}
+	if !found {
+		return tokens.TokenHistory{}, tokens.TokenNotFoundError{ID: id}
+	}
+	return tokens.NewTokenHistory(fromPostgres(res), usedAt.Time, revokedAt.Time), nil
+}
+
+func getTokensByProfileIDSQL(_ context.Context, table, profileID string, since, before time.Time, opts tokens.ListOptions, limit int) *pan.Query {
+	token := RefreshToken{table: table}
 	query := pan.New("SELECT " + pan.Columns(token).String() + " FROM " + pan.Table(token))
 	query.Where()
 	query.Comparison(token, "ProfileID", "=", profileID)
@@ -203,9 +1170,19 @@ func getTokensByProfileIDSQL(_ context.Context, profileID string, since, before
 	if !since.IsZero() {
 		query.Comparison(token, "CreatedAt", ">", since)
 	}
+	if opts.IncludeRevoked != nil && !*opts.IncludeRevoked {
+		query.Comparison(token, "Revoked", "=", false)
+	}
+	if opts.IncludeUsed != nil && !*opts.IncludeUsed {
+		query.Comparison(token, "Used", "=", false)
+	}
 	query.Flush(" AND ")
-	query.OrderByDesc(pan.Column(token, "CreatedAt"))
-	query.Limit(tokens.NumTokenResults)
+	if opts.Ascending {
+		query.OrderBy(pan.Column(token, "CreatedAt"))
+	} else {
+		query.OrderByDesc(pan.Column(token, "CreatedAt"))
+	}
+	query.Limit(int64(limit))
 	return query.Flush(" ")
 }
 
@@ -216,14 +1193,34 @@ func getTokensByProfileIDSQL(_ context.Context, profileID string, since, before
 // before `before` will be returned. tokens.RefreshTokens will be sorted by their CreatedAt property,
 // with the most recent coming first.
 func (s Storer) GetTokensByProfileID(ctx context.Context, profileID string, since, before time.Time) ([]tokens.RefreshToken, error) {
-	query := getTokensByProfileIDSQL(ctx, profileID, since, before)
+	toks, _, err := s.ListTokensByProfileID(ctx, profileID, since, before, tokens.ListOptions{})
+	return toks, err
+}
+
+// ListTokensByProfileID retrieves the same tokens.RefreshTokens as GetTokensByProfileID, and also
+// reports whether more than NumTokenResults tokens.RefreshTokens matched, meaning some were left
+// out of the results. It does this by requesting one more row than it returns. If `opts.Ascending`
+// is true, the oldest tokens.RefreshTokens are returned first. Revoked or used tokens.RefreshTokens
+// are left out if `opts` excludes them.
+func (s Storer) ListTokensByProfileID(ctx context.Context, profileID string, since, before time.Time, opts tokens.ListOptions) ([]tokens.RefreshToken, bool, error) {
+	if s.needsTimeoutTx() {
+		var toks []tokens.RefreshToken
+		var hasMore bool
+		err := s.inTx(ctx, s.readDB(), func(tx Storer) error {
+			var err error
+			toks, hasMore, err = tx.ListTokensByProfileID(ctx, profileID, since, before, opts)
+			return err
+		})
+		return toks, hasMore, err
+	}
+	query := getTokensByProfileIDSQL(ctx, s.tableName(), profileID, since, before, opts, tokens.NumTokenResults+1)
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
-		return []tokens.RefreshToken{}, err
+		return []tokens.RefreshToken{}, false, err
 	}
-	rows, err := s.db.Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	rows, err := s.readConn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
 	if err != nil {
-		return []tokens.RefreshToken{}, err
+		return []tokens.RefreshToken{}, false, err
 	}
 	defer closeRows(ctx, rows)
 	var toks []tokens.RefreshToken
@@ -231,14 +1228,166 @@ func (s Storer) GetTokensByProfileID(ctx context.Context, profileID string, sinc
 		var token RefreshToken
 		err = pan.Unmarshal(rows, &token)
 		if err != nil {
-			return toks, err
+			return toks, false, err
 		}
 		toks = append(toks, fromPostgres(token))
 	}
 	if err = rows.Err(); err != nil {
-		return toks, err
+		return toks, false, err
+	}
+	var hasMore bool
+	if len(toks) > tokens.NumTokenResults {
+		toks = toks[:tokens.NumTokenResults]
+		hasMore = true
+	}
+	return toks, hasMore, nil
+}
+
+func getLatestTokenSQL(_ context.Context, table, profileID, clientID string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("SELECT " + pan.Columns(t).String() + " FROM " + pan.Table(t))
+	query.Where()
+	query.Comparison(t, "ProfileID", "=", profileID)
+	query.Comparison(t, "ClientID", "=", clientID)
+	query.Comparison(t, "Revoked", "=", false)
+	query.Comparison(t, "Used", "=", false)
+	query.Flush(" AND ")
+	query.OrderByDesc(pan.Column(t, "CreatedAt"))
+	query.Limit(1)
+	return query.Flush(" ")
+}
+
+// GetLatestToken returns the most recently created tokens.RefreshToken in Storer with a ProfileID
+// matching `profileID` and a ClientID matching `clientID` that hasn't been revoked or used. If
+// there is none, an ErrTokenNotFound error is returned.
+func (s Storer) GetLatestToken(ctx context.Context, profileID, clientID string) (tokens.RefreshToken, error) {
+	if s.needsTimeoutTx() {
+		var res tokens.RefreshToken
+		err := s.inTx(ctx, s.readDB(), func(tx Storer) error {
+			var err error
+			res, err = tx.GetLatestToken(ctx, profileID, clientID)
+			return err
+		})
+		return res, err
+	}
+	query := getLatestTokenSQL(ctx, s.tableName(), profileID, clientID)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	rows, err := s.readConn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	defer closeRows(ctx, rows)
+	var res RefreshToken
+	var found bool
+	for rows.Next() {
+		err = pan.Unmarshal(rows, &res)
+		if err != nil {
+			return tokens.RefreshToken{}, err
+		}
+		found = true
+	}
+	if err = rows.Err(); err != nil {
+		return tokens.RefreshToken{}, err
+	}
+	if !found {
+		return tokens.RefreshToken{}, tokens.ErrTokenNotFound
+	}
+	return fromPostgres(res), nil
+}
+
+func getTokensByProfileIDsSQL(_ context.Context, table string, profileIDs []string, since, before time.Time, limit int) *pan.Query {
+	token := RefreshToken{table: table}
+	profileID, createdAt := pan.Column(token, "ProfileID"), pan.Column(token, "CreatedAt")
+	query := pan.New("SELECT " + pan.Columns(token).String() + " FROM (SELECT " + pan.Columns(token).String() +
+		", ROW_NUMBER() OVER (PARTITION BY " + profileID + " ORDER BY " + createdAt + " DESC) AS profile_row FROM " +
+		pan.Table(token))
+	query.Where()
+	query.Expression(profileID+" = ANY(?)", pq.Array(profileIDs))
+	if !before.IsZero() {
+		query.Comparison(token, "CreatedAt", "<", before)
+	}
+	if !since.IsZero() {
+		query.Comparison(token, "CreatedAt", ">", since)
+	}
+	query.Flush(" AND ")
+	query.Expression(") AS ranked WHERE profile_row <= ?", limit)
+	query.Expression("ORDER BY " + profileID + ", " + createdAt + " DESC")
+	return query.Flush(" ")
+}
+
+// GetTokensByProfileIDs retrieves up to NumTokenResults tokens.RefreshTokens from Storer for each
+// of `profileIDs`, filtered and sorted like GetTokensByProfileID, keyed by their ProfileID.
+// Profiles without any matching tokens.RefreshTokens are left out of the result. All the profiles
+// are retrieved in a single query, which ranks each profile's tokens.RefreshTokens to cap them.
+func (s Storer) GetTokensByProfileIDs(ctx context.Context, profileIDs []string, since, before time.Time) (map[string][]tokens.RefreshToken, error) {
+	if s.needsTimeoutTx() {
+		var res map[string][]tokens.RefreshToken
+		err := s.inTx(ctx, s.readDB(), func(tx Storer) error {
+			var err error
+			res, err = tx.GetTokensByProfileIDs(ctx, profileIDs, since, before)
+			return err
+		})
+		return res, err
+	}
+	res := make(map[string][]tokens.RefreshToken, len(profileIDs))
+	if len(profileIDs) < 1 {
+		return res, nil
+	}
+	query := getTokensByProfileIDsSQL(ctx, s.tableName(), profileIDs, since, before, tokens.NumTokenResults)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.readConn().Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(ctx, rows)
+	for rows.Next() {
+		var token RefreshToken
+		err = pan.Unmarshal(rows, &token)
+		if err != nil {
+			return nil, err
+		}
+		res[token.ProfileID] = append(res[token.ProfileID], fromPostgres(token))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func tokenStatsSQL(_ context.Context, table string) *pan.Query {
+	t := RefreshToken{table: table}
+	query := pan.New("SELECT COUNT(*), COUNT(*) FILTER (WHERE " + pan.Column(t, "Revoked") + "), COUNT(*) FILTER (WHERE " + pan.Column(t, "Used") + ") FROM " + pan.Table(t))
+	return query.Flush(" ")
+}
+
+// TokenStats returns the number of tokens.RefreshTokens in Storer, the
+// number of those that have been revoked, and the number of those that have
+// been used.
+func (s Storer) TokenStats(ctx context.Context) (total, revoked, used int, err error) {
+	if s.needsTimeoutTx() {
+		err = s.inTx(ctx, s.readDB(), func(tx Storer) error {
+			var err error
+			total, revoked, used, err = tx.TokenStats(ctx)
+			return err
+		})
+		return total, revoked, used, err
+	}
+	query := tokenStatsSQL(ctx, s.tableName())
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	err = s.readConn().QueryRow(queryStr, query.Args()...).Scan(&total, &revoked, &used)
+	if err != nil {
+		return 0, 0, 0, err
 	}
-	return toks, nil
+	return total, revoked, used, nil
 }
 
 func closeRows(ctx context.Context, rows *sql.Rows) {
@@ -0,0 +1,204 @@
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/postgres"
+)
+
+func newTestStorer(t testing.TB, opts ...postgres.Option) postgres.Storer {
+	t.Helper()
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	migs, err := postgres.Migrations(postgres.DefaultTableName)
+	if err != nil {
+		t.Fatalf("Error getting migrations: %+v\n", err)
+	}
+	_, err = migrate.Exec(db, "postgres", migs, migrate.Up)
+	if err != nil {
+		t.Fatalf("Error applying migrations: %+v\n", err)
+	}
+	storer := postgres.NewStorer(ctx, db, opts...)
+	t.Cleanup(func() {
+		if err := storer.Close(); err != nil {
+			t.Errorf("Error closing storer: %+v\n", err)
+		}
+	})
+	return storer
+}
+
+func TestPreparedStatements(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storer := newTestStorer(t)
+
+	for i := 0; i < 3; i++ {
+		token := tokens.RefreshToken{
+			ID:          fmt.Sprintf("prepared-%d", i),
+			CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			ClientID:    "client",
+			AccountID:   "account",
+			Scopes:      []string{"a"},
+		}
+		err := storer.CreateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Error creating token %s: %+v\n", token.ID, err)
+		}
+
+		// each query runs more than once, so the cached statements get reused
+		for j := 0; j < 2; j++ {
+			result, err := storer.GetToken(ctx, token.ID)
+			if err != nil {
+				t.Fatalf("Error retrieving token %s: %+v\n", token.ID, err)
+			}
+			if diff := cmp.Diff(token, result); diff != "" {
+				t.Errorf("Unexpected diff for %s (-wanted, +got): %s", token.ID, diff)
+			}
+		}
+		err = storer.UseToken(ctx, token.ID)
+		if err != nil {
+			t.Errorf("Error using token %s: %+v\n", token.ID, err)
+		}
+		err = storer.UseToken(ctx, token.ID)
+		if !errors.Is(err, tokens.ErrTokenUsed) {
+			t.Errorf("Expected tokens.ErrTokenUsed for %s, got %+v\n", token.ID, err)
+		}
+	}
+
+	err := storer.UseToken(ctx, "prepared-missing")
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected tokens.ErrTokenNotFound, got %+v\n", err)
+	}
+
+	// the cached statements have to work inside transactions, too
+	err = storer.WithTransaction(ctx, func(tx tokens.Storer) error {
+		_, err := tx.GetToken(ctx, "prepared-0")
+		if err != nil {
+			return err
+		}
+		return tx.UseToken(ctx, "prepared-missing")
+	})
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected tokens.ErrTokenNotFound from transaction, got %+v\n", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storer := postgres.NewStorer(ctx, openRecorder(t, "primary"), postgres.WithReplicas(openRecorder(t, "replica")))
+
+	_, err := storer.GetToken(ctx, "token")
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Fatalf("Expected tokens.ErrTokenNotFound before closing, got %+v\n", err)
+	}
+	err = storer.CreateToken(ctx, tokens.RefreshToken{ID: "token"})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token before closing: %+v\n", err)
+	}
+
+	err = storer.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error closing storer: %+v\n", err)
+	}
+
+	_, err = storer.GetToken(ctx, "token")
+	if err == nil || errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected an error reading from a closed storer, got %+v\n", err)
+	}
+	err = storer.CreateToken(ctx, tokens.RefreshToken{ID: "token"})
+	if err == nil {
+		t.Error("Expected an error writing to a closed storer, got nil")
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storer := postgres.NewStorer(ctx, openRecorder(t, "primary"),
+		postgres.WithReplicas(openRecorder(t, "replica")),
+		postgres.WithStatementTimeout(1500*time.Millisecond),
+	)
+
+	_, err := storer.GetTokens(ctx, []string{"token"})
+	if err != nil {
+		t.Errorf("Unexpected error retrieving tokens: %+v\n", err)
+	}
+	err = storer.UseToken(ctx, "token")
+	if err != nil {
+		t.Errorf("Unexpected error using token: %+v\n", err)
+	}
+	err = storer.WithTransaction(ctx, func(tx tokens.Storer) error {
+		return tx.CreateToken(ctx, tokens.RefreshToken{ID: "token"})
+	})
+	if err != nil {
+		t.Errorf("Unexpected error creating token in transaction: %+v\n", err)
+	}
+
+	const setTimeout = "SET LOCAL statement_timeout = 1500"
+	for name, expected := range map[string]int{"primary": 2, "replica": 1} {
+		var sets int
+		queries := recorder.recorded(t.Name() + "/" + name)
+		for pos, query := range queries {
+			if query != setTimeout {
+				continue
+			}
+			sets++
+			if pos+1 >= len(queries) {
+				t.Errorf("Expected a query after setting the timeout on %s, got none", name)
+			}
+		}
+		if sets != expected {
+			t.Errorf("Expected the timeout to be set %d times on %s, got %d: %q", expected, name, sets, queries)
+		}
+		if len(queries) < 1 || queries[0] != setTimeout {
+			t.Errorf("Expected the timeout to be set before any queries on %s, got %q", name, queries)
+		}
+	}
+}
+
+func BenchmarkGetToken(b *testing.B) {
+	for name, opts := range map[string][]postgres.Option{
+		"prepared":   nil,
+		"unprepared": {postgres.WithoutPreparedStatements()},
+	} {
+		opts := opts
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			storer := newTestStorer(b, opts...)
+			token := tokens.RefreshToken{
+				ID:          "benchmark",
+				CreatedAt:   time.Now().Round(time.Millisecond),
+				CreatedFrom: "benchmark",
+				ProfileID:   "profile",
+				ClientID:    "client",
+				AccountID:   "account",
+				Scopes:      []string{"a"},
+			}
+			err := storer.CreateToken(ctx, token)
+			if err != nil {
+				b.Fatalf("Error creating token: %+v\n", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := storer.GetToken(ctx, token.ID)
+				if err != nil {
+					b.Fatalf("Error retrieving token: %+v\n", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/postgres"
+)
+
+// recordingDriver is a database/sql driver that doesn't run queries, it
+// just records which were run against each data source name.
+type recordingDriver struct {
+	lock    sync.Mutex
+	counts  map[string]int
+	queries map[string][]string
+}
+
+var recorder = &recordingDriver{counts: map[string]int{}, queries: map[string][]string{}}
+
+func init() { //nolint:gochecknoinits // drivers can only be registered once
+	sql.Register("recorder", recorder)
+}
+
+func (d *recordingDriver) record(name, query string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.counts[name]++
+	d.queries[name] = append(d.queries[name], query)
+}
+
+func (d *recordingDriver) count(name string) int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.counts[name]
+}
+
+func (d *recordingDriver) recorded(name string) []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string(nil), d.queries[name]...)
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return recordingConn{driver: d, name: name}, nil
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+	name   string
+}
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return recordingStmt{driver: c.driver, name: c.name, query: query}, nil
+}
+
+func (recordingConn) Close() error { return nil }
+
+func (recordingConn) Begin() (driver.Tx, error) {
+	return recordingTx{}, nil
+}
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+type recordingStmt struct {
+	driver *recordingDriver
+	name   string
+	query  string
+}
+
+func (recordingStmt) Close() error  { return nil }
+func (recordingStmt) NumInput() int { return -1 }
+
+func (s recordingStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.driver.record(s.name, s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s recordingStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.driver.record(s.name, s.query)
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return nil }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+func openRecorder(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("recorder", t.Name()+"/"+name)
+	if err != nil {
+		t.Fatalf("Error opening %s: %+v\n", name, err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Error closing %s: %+v\n", name, err)
+		}
+	})
+	return db
+}
+
+func TestReplicaRouting(t *testing.T) {
+	t.Parallel()
+
+	for name, opts := range map[string][]postgres.Option{
+		"prepared":   nil,
+		"unprepared": {postgres.WithoutPreparedStatements()},
+	} {
+		opts := opts
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			primary := openRecorder(t, "primary")
+			storer := postgres.NewStorer(ctx, primary, append(opts, postgres.WithReplicas(
+				openRecorder(t, "replica-1"), openRecorder(t, "replica-2"),
+			))...)
+
+			for i := 0; i < 6; i++ {
+				_, err := storer.GetTokensByProfileID(ctx, "profile", time.Time{}, time.Time{})
+				if err != nil {
+					t.Errorf("Unexpected error listing tokens: %+v\n", err)
+				}
+			}
+			if primaryCount := recorder.count(t.Name() + "/primary"); primaryCount != 0 {
+				t.Errorf("Expected reads not to hit the primary, got %d queries", primaryCount)
+			}
+			for _, replica := range []string{"replica-1", "replica-2"} {
+				if count := recorder.count(t.Name() + "/" + replica); count != 3 {
+					t.Errorf("Expected %d queries against %s, got %d", 3, replica, count)
+				}
+			}
+
+			// GetToken is what Validate uses, so it always reads the
+			// primary, to see tokens that were just used or revoked
+			_, err := storer.GetToken(ctx, "token")
+			if !errors.Is(err, tokens.ErrTokenNotFound) {
+				t.Errorf("Expected tokens.ErrTokenNotFound, got %+v\n", err)
+			}
+			err = storer.CreateToken(ctx, tokens.RefreshToken{ID: "token"})
+			if err != nil {
+				t.Errorf("Unexpected error creating token: %+v\n", err)
+			}
+			revoked := true
+			_, err = storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: "token", Revoked: &revoked})
+			if err != nil {
+				t.Errorf("Unexpected error updating tokens: %+v\n", err)
+			}
+			err = storer.UseToken(ctx, "token")
+			if err != nil {
+				t.Errorf("Unexpected error using token: %+v\n", err)
+			}
+			if count := recorder.count(t.Name() + "/primary"); count != 4 {
+				t.Errorf("Expected %d queries against the primary, got %d", 4, count)
+			}
+			for _, replica := range []string{"replica-1", "replica-2"} {
+				if count := recorder.count(t.Name() + "/" + replica); count != 3 {
+					t.Errorf("Expected writes not to hit %s, got %d queries", replica, count-3)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"impractical.co/pqarrays"
+)
+
+// ErrNullArrayElement is returned by StringArrayStrict when scanning an
+// array that contains a NULL element.
+var ErrNullArrayElement = errors.New("array contains a NULL element")
+
+// StringArrayStrict is a PostgreSQL text array, like pqarrays.StringArray.
+// pqarrays.StringArray silently drops any NULL elements when scanning,
+// turning {a,NULL,b} into [a b]; StringArrayStrict returns an
+// ErrNullArrayElement error instead, for columns where a NULL element
+// indicates data corruption rather than something to ignore.
+type StringArrayStrict []string
+
+// Scan implements sql.Scanner, parsing PostgreSQL array syntax into `s`.
+func (s *StringArrayStrict) Scan(src interface{}) error {
+	var elems []sql.NullString
+	err := pq.GenericArray{A: &elems}.Scan(src)
+	if err != nil {
+		return err
+	}
+	if elems == nil {
+		*s = nil
+		return nil
+	}
+	res := make(StringArrayStrict, 0, len(elems))
+	for pos, elem := range elems {
+		if !elem.Valid {
+			return fmt.Errorf("%w at index %d", ErrNullArrayElement, pos)
+		}
+		res = append(res, elem.String)
+	}
+	*s = res
+	return nil
+}
+
+// Value implements driver.Valuer, encoding `s` the same way
+// pqarrays.StringArray does.
+func (s StringArrayStrict) Value() (driver.Value, error) {
+	return pqarrays.StringArray(s).Value()
+}
@@ -0,0 +1,55 @@
+package postgres_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"impractical.co/pqarrays"
+
+	"lockbox.dev/tokens/storers/postgres"
+)
+
+func TestStringArrayNullElements(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		input   string
+		lenient []string
+		strict  []string
+		err     error
+	}
+	for _, test := range []testCase{
+		{input: `{a,b,c}`, lenient: []string{"a", "b", "c"}, strict: []string{"a", "b", "c"}},
+		{input: `{"a b",c}`, lenient: []string{"a b", "c"}, strict: []string{"a b", "c"}},
+		{input: `{"NULL",c}`, lenient: []string{"NULL", "c"}, strict: []string{"NULL", "c"}},
+		{input: `{a,NULL,b}`, lenient: []string{"a", "b"}, err: postgres.ErrNullArrayElement},
+		{input: `{NULL}`, lenient: nil, err: postgres.ErrNullArrayElement},
+	} {
+		test := test
+		t.Run(test.input, func(t *testing.T) {
+			t.Parallel()
+
+			var lenient pqarrays.StringArray
+			err := lenient.Scan([]byte(test.input))
+			if err != nil {
+				t.Fatalf("Unexpected error scanning leniently: %+v\n", err)
+			}
+			if diff := cmp.Diff(test.lenient, []string(lenient)); diff != "" {
+				t.Errorf("Unexpected lenient diff (-wanted, +got): %s", diff)
+			}
+
+			var strict postgres.StringArrayStrict
+			err = strict.Scan([]byte(test.input))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Expected error %v scanning strictly, got %+v\n", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.strict, []string(strict)); diff != "" {
+				t.Errorf("Unexpected strict diff (-wanted, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE tokens ADD COLUMN created_ip VARCHAR(45),
+		   ADD COLUMN created_user_agent TEXT;
+
+-- +migrate Down
+ALTER TABLE tokens DROP COLUMN IF EXISTS created_ip,
+		   DROP COLUMN IF EXISTS created_user_agent;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE tokens ADD COLUMN reuse_attempts INT NOT NULL DEFAULT 0;
+
+-- +migrate Down
+ALTER TABLE tokens DROP COLUMN IF EXISTS reuse_attempts;
@@ -0,0 +1,8 @@
+-- +migrate Up
+ALTER TABLE tokens ADD COLUMN family_id VARCHAR(64) NOT NULL DEFAULT '';
+CREATE INDEX tokens_family_id_idx ON tokens (family_id);
+UPDATE tokens SET family_id = id WHERE family_id = '';
+
+-- +migrate Down
+DROP INDEX IF EXISTS tokens_family_id_idx;
+ALTER TABLE tokens DROP COLUMN IF EXISTS family_id;
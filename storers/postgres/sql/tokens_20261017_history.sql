@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE tokens ADD COLUMN used_at TIMESTAMPTZ NULL;
+ALTER TABLE tokens ADD COLUMN revoked_at TIMESTAMPTZ NULL;
+
+-- +migrate Down
+ALTER TABLE tokens DROP COLUMN IF EXISTS revoked_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS used_at;
@@ -14,7 +14,6 @@ import (
 	migrate "github.com/rubenv/sql-migrate"
 
 	"lockbox.dev/tokens"
-	"lockbox.dev/tokens/storers/postgres/migrations"
 )
 
 // Factory is a generator of Storers for testing purposes. It knows how to
@@ -23,16 +22,19 @@ import (
 type Factory struct {
 	db        *sql.DB
 	databases map[string]*sql.DB
+	opts      []Option
 	lock      sync.Mutex
 }
 
 // NewFactory returns a Factory that is ready to be used. The passed sql.DB
 // will be used as a control plane connection, but each test will have its own
-// database created for that test.
-func NewFactory(db *sql.DB) *Factory {
+// database created for that test. The Storers created will be configured with
+// `opts`.
+func NewFactory(db *sql.DB, opts ...Option) *Factory {
 	return &Factory{
 		db:        db,
 		databases: map[string]*sql.DB{},
+		opts:      opts,
 	}
 }
 
@@ -78,17 +80,15 @@ func (f *Factory) NewStorer(ctx context.Context) (tokens.Storer, error) { //noli
 	f.databases[database] = newConn
 	f.lock.Unlock()
 
-	migs := &migrate.AssetMigrationSource{
-		Asset:    migrations.Asset,
-		AssetDir: migrations.AssetDir,
-		Dir:      "sql",
+	storer := NewStorer(ctx, newConn, f.opts...)
+	migs, err := Migrations(storer.tableName())
+	if err != nil {
+		return nil, err
 	}
 	_, err = migrate.Exec(newConn, "postgres", migs, migrate.Up)
 	if err != nil {
 		return nil, err
 	}
-
-	storer := NewStorer(ctx, newConn)
 	return storer, nil
 }
 
@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"database/sql"
 	"time"
 
 	"impractical.co/pqarrays"
@@ -10,47 +11,76 @@ import (
 
 // RefreshToken represents a refresh token that can be used to obtain a new access token.
 type RefreshToken struct {
-	ID          string
-	CreatedAt   time.Time
-	CreatedFrom string
-	Scopes      pqarrays.StringArray
-	ProfileID   string
-	ClientID    string
-	AccountID   string
-	Revoked     bool
-	Used        bool
+	ID               string
+	CreatedAt        time.Time
+	CreatedFrom      string
+	CreatedIP        sql.NullString `sql_column:"created_ip"`
+	CreatedUserAgent sql.NullString
+	Scopes           pqarrays.StringArray
+	ProfileID        string
+	ClientID         string
+	AccountID        string
+	FamilyID         string
+	Revoked          bool
+	Used             bool
+
+	// table is the name of the table the RefreshToken is stored in. If
+	// empty, DefaultTableName is used.
+	table string `sql_column:"-"`
 }
 
+// fromPostgres converts `token` to a tokens.RefreshToken. Tokens without
+// scopes always have nil Scopes, whether they were scanned as nil or as
+// an empty array, to match the other Storers.
 func fromPostgres(token RefreshToken) tokens.RefreshToken {
+	var scopes []string
+	if len(token.Scopes) > 0 {
+		scopes = []string(token.Scopes)
+	}
 	return tokens.RefreshToken{
-		ID:          token.ID,
-		CreatedAt:   token.CreatedAt,
-		CreatedFrom: token.CreatedFrom,
-		Scopes:      []string(token.Scopes),
-		ProfileID:   token.ProfileID,
-		ClientID:    token.ClientID,
-		AccountID:   token.AccountID,
-		Revoked:     token.Revoked,
-		Used:        token.Used,
+		ID:               token.ID,
+		CreatedAt:        token.CreatedAt,
+		CreatedFrom:      token.CreatedFrom,
+		CreatedIP:        token.CreatedIP.String,
+		CreatedUserAgent: token.CreatedUserAgent.String,
+		Scopes:           scopes,
+		ProfileID:        token.ProfileID,
+		ClientID:         token.ClientID,
+		AccountID:        token.AccountID,
+		FamilyID:         token.FamilyID,
+		Revoked:          token.Revoked,
+		Used:             token.Used,
 	}
 }
 
+// toPostgres converts `token` to a RefreshToken. Tokens without scopes
+// are stored with an empty array, as the scopes column can't be NULL.
 func toPostgres(token tokens.RefreshToken) RefreshToken {
+	scopes := pqarrays.StringArray(token.Scopes)
+	if scopes == nil {
+		scopes = pqarrays.StringArray{}
+	}
 	return RefreshToken{
-		ID:          token.ID,
-		CreatedAt:   token.CreatedAt,
-		CreatedFrom: token.CreatedFrom,
-		Scopes:      pqarrays.StringArray(token.Scopes),
-		ProfileID:   token.ProfileID,
-		ClientID:    token.ClientID,
-		AccountID:   token.AccountID,
-		Revoked:     token.Revoked,
-		Used:        token.Used,
+		ID:               token.ID,
+		CreatedAt:        token.CreatedAt,
+		CreatedFrom:      token.CreatedFrom,
+		CreatedIP:        sql.NullString{String: token.CreatedIP, Valid: token.CreatedIP != ""},
+		CreatedUserAgent: sql.NullString{String: token.CreatedUserAgent, Valid: token.CreatedUserAgent != ""},
+		Scopes:           scopes,
+		ProfileID:        token.ProfileID,
+		ClientID:         token.ClientID,
+		AccountID:        token.AccountID,
+		FamilyID:         token.FamilyID,
+		Revoked:          token.Revoked,
+		Used:             token.Used,
 	}
 }
 
 // GetSQLTableName returns the name of the PostgreSQL table RefreshTokens will be stored
 // in. It is required for use with pan.
-func (RefreshToken) GetSQLTableName() string {
-	return "tokens"
+func (t RefreshToken) GetSQLTableName() string {
+	if t.table == "" {
+		return DefaultTableName
+	}
+	return t.table
 }
@@ -0,0 +1,210 @@
+package slowlog
+
+import (
+	"context"
+	"time"
+
+	"yall.in"
+
+	"lockbox.dev/tokens"
+)
+
+var _ tokens.TxStorer = Storer{}
+
+// Storer is an implementation of the Storer interface that wraps another
+// Storer, timing each call to it. Calls that take at least as long as
+// the Storer's threshold are logged as warnings, with the method name
+// and how long they took. Results from the wrapped Storer are returned
+// unchanged.
+type Storer struct {
+	inner     tokens.Storer
+	threshold time.Duration
+	log       *yall.Logger
+
+	// OnSlow, if set, is called with the method name and duration of
+	// each slow call, after it's logged.
+	OnSlow func(ctx context.Context, method string, elapsed time.Duration)
+}
+
+// NewStorer returns an instance of Storer that is ready to be used as a
+// Storer, wrapping `inner` and logging calls that take `threshold` or
+// longer to `log`. If `log` is nil, the logger in each call's context is
+// used.
+func NewStorer(inner tokens.Storer, threshold time.Duration, log *yall.Logger) Storer {
+	return Storer{inner: inner, threshold: threshold, log: log}
+}
+
+// observe logs the call to `method` that began at `start` if it took at
+// least as long as the threshold.
+func (s Storer) observe(ctx context.Context, method string, start time.Time) {
+	elapsed := time.Since(start)
+	if elapsed < s.threshold {
+		return
+	}
+	log := s.log
+	if log == nil {
+		log = yall.FromContext(ctx)
+	}
+	log.WithField("method", method).WithField("duration", elapsed.String()).Warn("slow storer operation")
+	if s.OnSlow != nil {
+		s.OnSlow(ctx, method, elapsed)
+	}
+}
+
+// GetToken retrieves the tokens.RefreshToken with an ID matching `token`
+// from the wrapped Storer.
+func (s Storer) GetToken(ctx context.Context, token string) (tokens.RefreshToken, error) {
+	defer s.observe(ctx, "GetToken", time.Now())
+	return s.inner.GetToken(ctx, token)
+}
+
+// GetTokens retrieves the tokens.RefreshTokens with IDs matching `ids`
+// from the wrapped Storer, keyed by their IDs.
+func (s Storer) GetTokens(ctx context.Context, ids []string) (map[string]tokens.RefreshToken, error) {
+	defer s.observe(ctx, "GetTokens", time.Now())
+	return s.inner.GetTokens(ctx, ids)
+}
+
+// CreateToken inserts the passed tokens.RefreshToken into the wrapped
+// Storer.
+func (s Storer) CreateToken(ctx context.Context, token tokens.RefreshToken) error {
+	defer s.observe(ctx, "CreateToken", time.Now())
+	return s.inner.CreateToken(ctx, token)
+}
+
+// CreateOrGetToken inserts the passed tokens.RefreshToken into the
+// wrapped Storer unless it already exists, returning the stored
+// tokens.RefreshToken and whether it was just created.
+func (s Storer) CreateOrGetToken(ctx context.Context, token tokens.RefreshToken) (tokens.RefreshToken, bool, error) {
+	defer s.observe(ctx, "CreateOrGetToken", time.Now())
+	return s.inner.CreateOrGetToken(ctx, token)
+}
+
+// UpdateTokens applies `change` to all the tokens.RefreshTokens in the
+// wrapped Storer that match the ID, ProfileID, ClientID, or AccountID
+// constraints of `change`, returning the IDs that matched.
+func (s Storer) UpdateTokens(ctx context.Context, change tokens.RefreshTokenChange) ([]string, error) {
+	defer s.observe(ctx, "UpdateTokens", time.Now())
+	return s.inner.UpdateTokens(ctx, change)
+}
+
+// UpdateTokensBatched applies `change` to all the tokens.RefreshTokens in
+// the wrapped Storer that match the ID, ProfileID, ClientID, or AccountID
+// constraints of `change`, `batchSize` at a time, returning how many
+// matched. The whole operation is timed, not each batch.
+func (s Storer) UpdateTokensBatched(ctx context.Context, change tokens.RefreshTokenChange, batchSize int) (int, error) {
+	defer s.observe(ctx, "UpdateTokensBatched", time.Now())
+	return s.inner.UpdateTokensBatched(ctx, change, batchSize)
+}
+
+// UseToken marks the tokens.RefreshToken specified by `id` as used in the
+// wrapped Storer.
+func (s Storer) UseToken(ctx context.Context, id string) error {
+	defer s.observe(ctx, "UseToken", time.Now())
+	return s.inner.UseToken(ctx, id)
+}
+
+// UseAndGetToken atomically marks the tokens.RefreshToken specified by
+// `id` as used in the wrapped Storer, returning it as it was before.
+func (s Storer) UseAndGetToken(ctx context.Context, id string) (tokens.RefreshToken, error) {
+	defer s.observe(ctx, "UseAndGetToken", time.Now())
+	return s.inner.UseAndGetToken(ctx, id)
+}
+
+// TouchToken sets the CreatedAt of the tokens.RefreshToken specified by
+// `id` to now in the wrapped Storer.
+func (s Storer) TouchToken(ctx context.Context, id string) error {
+	defer s.observe(ctx, "TouchToken", time.Now())
+	return s.inner.TouchToken(ctx, id)
+}
+
+// RevokeTokens marks the tokens.RefreshTokens with IDs matching `ids` as
+// revoked in the wrapped Storer, returning how many were revoked.
+func (s Storer) RevokeTokens(ctx context.Context, ids []string) (int, error) {
+	defer s.observe(ctx, "RevokeTokens", time.Now())
+	return s.inner.RevokeTokens(ctx, ids)
+}
+
+// RevokeTokenFamily marks the tokens.RefreshTokens with a FamilyID
+// matching `familyID` as revoked in the wrapped Storer, returning how
+// many were revoked.
+func (s Storer) RevokeTokenFamily(ctx context.Context, familyID string) (int, error) {
+	defer s.observe(ctx, "RevokeTokenFamily", time.Now())
+	return s.inner.RevokeTokenFamily(ctx, familyID)
+}
+
+// MarkTokenReuseAttempt records a reuse attempt for the
+// tokens.RefreshToken specified by `id` in the wrapped Storer.
+func (s Storer) MarkTokenReuseAttempt(ctx context.Context, id string) (int, error) {
+	defer s.observe(ctx, "MarkTokenReuseAttempt", time.Now())
+	return s.inner.MarkTokenReuseAttempt(ctx, id)
+}
+
+// GetReuseAttempts returns the number of reuse attempts recorded for the
+// tokens.RefreshToken specified by `id` in the wrapped Storer.
+func (s Storer) GetReuseAttempts(ctx context.Context, id string) (int, error) {
+	defer s.observe(ctx, "GetReuseAttempts", time.Now())
+	return s.inner.GetReuseAttempts(ctx, id)
+}
+
+// GetTokensByProfileID retrieves up to NumTokenResults
+// tokens.RefreshTokens with a ProfileID matching `profileID` from the
+// wrapped Storer.
+func (s Storer) GetTokensByProfileID(ctx context.Context, profileID string, since, before time.Time) ([]tokens.RefreshToken, error) {
+	defer s.observe(ctx, "GetTokensByProfileID", time.Now())
+	return s.inner.GetTokensByProfileID(ctx, profileID, since, before)
+}
+
+// ListTokensByProfileID retrieves up to NumTokenResults
+// tokens.RefreshTokens with a ProfileID matching `profileID` from the
+// wrapped Storer, along with whether more matched.
+func (s Storer) ListTokensByProfileID(ctx context.Context, profileID string, since, before time.Time, opts tokens.ListOptions) ([]tokens.RefreshToken, bool, error) {
+	defer s.observe(ctx, "ListTokensByProfileID", time.Now())
+	return s.inner.ListTokensByProfileID(ctx, profileID, since, before, opts)
+}
+
+// GetTokensByProfileIDs retrieves up to NumTokenResults tokens.RefreshTokens
+// for each of `profileIDs` from the wrapped Storer, keyed by their
+// ProfileID.
+func (s Storer) GetTokensByProfileIDs(ctx context.Context, profileIDs []string, since, before time.Time) (map[string][]tokens.RefreshToken, error) {
+	defer s.observe(ctx, "GetTokensByProfileIDs", time.Now())
+	return s.inner.GetTokensByProfileIDs(ctx, profileIDs, since, before)
+}
+
+// GetLatestToken returns the most recent live tokens.RefreshToken for
+// `profileID` and `clientID` from the wrapped Storer.
+func (s Storer) GetLatestToken(ctx context.Context, profileID, clientID string) (tokens.RefreshToken, error) {
+	defer s.observe(ctx, "GetLatestToken", time.Now())
+	return s.inner.GetLatestToken(ctx, profileID, clientID)
+}
+
+// GetTokenHistory returns the history of the tokens.RefreshToken specified
+// by `id` from the wrapped Storer.
+func (s Storer) GetTokenHistory(ctx context.Context, id string) (tokens.TokenHistory, error) {
+	defer s.observe(ctx, "GetTokenHistory", time.Now())
+	return s.inner.GetTokenHistory(ctx, id)
+}
+
+// TokenStats returns the token counts from the wrapped Storer.
+func (s Storer) TokenStats(ctx context.Context) (total, revoked, used int, err error) {
+	defer s.observe(ctx, "TokenStats", time.Now())
+	return s.inner.TokenStats(ctx)
+}
+
+// WithTransaction calls `fn` with a Storer whose operations all take place
+// in a single transaction of the wrapped Storer, and are timed like
+// Storer's are. The transaction as a whole is timed too. If the wrapped
+// Storer isn't a tokens.TxStorer, tokens.ErrTransactionsUnsupported is
+// returned without calling `fn`.
+func (s Storer) WithTransaction(ctx context.Context, fn func(tx tokens.Storer) error) error {
+	txStorer, ok := s.inner.(tokens.TxStorer)
+	if !ok {
+		return tokens.ErrTransactionsUnsupported
+	}
+	defer s.observe(ctx, "WithTransaction", time.Now())
+	return txStorer.WithTransaction(ctx, func(tx tokens.Storer) error {
+		wrapped := s
+		wrapped.inner = tx
+		return fn(wrapped)
+	})
+}
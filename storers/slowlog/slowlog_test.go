@@ -0,0 +1,107 @@
+package slowlog_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/memory"
+	"lockbox.dev/tokens/storers/slowlog"
+	"lockbox.dev/tokens/tokenstest"
+)
+
+// slowStorer is a tokens.Storer whose GetToken calls always take at least
+// a delay to return.
+type slowStorer struct {
+	tokens.Storer
+	delay time.Duration
+}
+
+func (s slowStorer) GetToken(ctx context.Context, id string) (tokens.RefreshToken, error) {
+	time.Sleep(s.delay)
+	return s.Storer.GetToken(ctx, id)
+}
+
+func TestSlowCallsReported(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	storer := slowlog.NewStorer(slowStorer{Storer: inner, delay: 50 * time.Millisecond}, 20*time.Millisecond, nil)
+
+	var lock sync.Mutex
+	var slow []string
+	storer.OnSlow = func(_ context.Context, method string, elapsed time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+		if elapsed < 20*time.Millisecond {
+			t.Errorf("Expected slow %s to take at least the threshold, took %s", method, elapsed)
+		}
+		slow = append(slow, method)
+	}
+
+	token := tokenstest.NewToken(t)
+	err = storer.CreateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Error creating token: %+v\n", err)
+	}
+	result, err := storer.GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+	err = storer.UseToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error using token: %+v\n", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if diff := cmp.Diff([]string{"GetToken"}, slow); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+}
+
+func TestWithTransactionTimed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inner, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating memory storer: %+v\n", err)
+	}
+	storer := slowlog.NewStorer(inner, 0, nil)
+	var slow []string
+	storer.OnSlow = func(_ context.Context, method string, _ time.Duration) {
+		slow = append(slow, method)
+	}
+
+	token := tokenstest.NewToken(t)
+	err = storer.WithTransaction(ctx, func(tx tokens.Storer) error {
+		return tx.CreateToken(ctx, token)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error in transaction: %+v\n", err)
+	}
+	if diff := cmp.Diff([]string{"CreateToken", "WithTransaction"}, slow); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	err = slowlog.NewStorer(slowStorer{Storer: inner}, 0, nil).WithTransaction(ctx, func(_ tokens.Storer) error {
+		t.Error("Expected WithTransaction not to call its function")
+		return nil
+	})
+	if !errors.Is(err, tokens.ErrTransactionsUnsupported) {
+		t.Errorf("Expected tokens.ErrTransactionsUnsupported, got %+v\n", err)
+	}
+}
@@ -2,9 +2,13 @@ package tokens
 
 import (
 	"context"
+	"crypto"
 	"crypto/rsa"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -17,6 +21,48 @@ const (
 	// NumTokenResults is the number of Tokens to retrieve when listing Tokens.
 	NumTokenResults = 25
 
+	// MaxCreatedAtSkew is how far in the future a RefreshToken's CreatedAt
+	// can be and still be considered valid, to allow for clock drift
+	// between servers.
+	MaxCreatedAtSkew = time.Minute * 5
+
+	// MaxTokenIDLength is the longest ID, in bytes, that can be generated
+	// for a RefreshToken, including Dependencies.IDPrefix. It's the
+	// longest ID the PostgreSQL Storer can store.
+	MaxTokenIDLength = 64
+
+	// DefaultMaxScopes is the maximum number of scopes a RefreshToken can
+	// have when Dependencies.MaxScopes isn't set.
+	DefaultMaxScopes = 256
+
+	// DefaultMaxScopesLength is the maximum combined length, in bytes, of
+	// a RefreshToken's scopes when Dependencies.MaxScopesLength isn't set.
+	DefaultMaxScopesLength = 32 * 1024
+
+	// DefaultMinRSAKeyBits is the smallest RSA key, in bits, that JWTs
+	// can be signed or verified with when Dependencies.MinRSAKeyBits isn't
+	// set. NewRSASigner always requires keys at least this big.
+	DefaultMinRSAKeyBits = 2048
+
+	// DefaultMaxJWTLength is the longest token string, in bytes, that will
+	// be parsed when Dependencies.MaxJWTLength isn't set. It's well above
+	// the length of the JWTs CreateJWT issues, even with 8192-bit keys.
+	DefaultMaxJWTLength = 8 * 1024
+
+	// DefaultScopeDelimiter is the delimiter used to join a RefreshToken's
+	// scopes into a single string when Dependencies.ScopeDelimiter isn't
+	// set, as specified by RFC 6749.
+	DefaultScopeDelimiter = " "
+
+	// DefaultTokenType is the token_use claim CreateJWT includes when
+	// Dependencies.TokenType isn't set.
+	DefaultTokenType = "refresh"
+
+	// DefaultUpdateBatchSize is the number of RefreshTokens
+	// Storer.UpdateTokensBatched changes at a time when it isn't passed a
+	// batch size.
+	DefaultUpdateBatchSize = 1000
+
 	refreshLength = time.Hour * 24 * 14
 )
 
@@ -32,6 +78,9 @@ var (
 	ErrTokenRevoked = errors.New("token revoked")
 	// ErrTokenUsed is returned when the Token identified by Validate has already been used.
 	ErrTokenUsed = errors.New("token used")
+	// ErrTokenExpired is returned when the Token identified by Validate has
+	// a valid signature but has expired.
+	ErrTokenExpired = errors.New("token expired")
 	// ErrNoTokenChangeFilter is returned when a TokenChange is passed to UpdateTokens
 	// that has none of the filtering fields set.
 	ErrNoTokenChangeFilter = errors.New("invalid token change: must have one or more filter fields set")
@@ -41,40 +90,182 @@ var (
 	// ErrUnknownSigningKey is returned when validating a token that claims
 	// to have been signed with an unrecognized signing key.
 	ErrUnknownSigningKey = errors.New("unknown signing key")
+	// ErrTokenCreatedInFuture is returned when a Token has a CreatedAt
+	// property that is more than MaxCreatedAtSkew in the future.
+	ErrTokenCreatedInFuture = errors.New("token created in the future")
+	// ErrTooManyScopes is returned when a Token has more scopes than the
+	// configured maximum.
+	ErrTooManyScopes = errors.New("too many scopes")
+	// ErrScopesTooLong is returned when the combined length of a Token's
+	// scopes is more than the configured maximum.
+	ErrScopesTooLong = errors.New("scopes too long")
+	// ErrTokenCreatedBeforeEpoch is returned when a Token has a CreatedAt
+	// property that is before the configured minimum.
+	ErrTokenCreatedBeforeEpoch = errors.New("token created before epoch")
+	// ErrRSAKeyTooSmall is returned when an RSA key is smaller than the
+	// configured minimum.
+	ErrRSAKeyTooSmall = errors.New("RSA key too small")
+	// ErrInvalidUUID is returned when a Token has a ProfileID, ClientID,
+	// or AccountID that isn't a UUID, and UUIDs are required.
+	ErrInvalidUUID = errors.New("invalid UUID")
+	// ErrInvalidScope is returned when a Token has a scope that can't be
+	// used, like one containing the scope delimiter.
+	ErrInvalidScope = errors.New("invalid scope")
+	// ErrInvalidCreatedIP is returned when a Token has a CreatedIP that
+	// isn't a single IP address.
+	ErrInvalidCreatedIP = errors.New("invalid created IP")
+	// ErrInvalidTokenID is returned when a Token has an ID that can't be
+	// used, like one containing the "." separator used in token strings.
+	ErrInvalidTokenID = errors.New("invalid token ID")
+	// ErrRevocationListUnavailable is returned by a RevocationList that
+	// can't say whether a Token is revoked, like one that hasn't been
+	// loaded yet or is out of date.
+	ErrRevocationListUnavailable = errors.New("revocation list unavailable")
+	// ErrTransactionsUnsupported is returned by the WithTransaction method of
+	// a Storer that wraps another, when the wrapped Storer isn't a TxStorer.
+	ErrTransactionsUnsupported = errors.New("transactions unsupported")
+	// ErrUnsupportedKey is returned when loading a private key that isn't
+	// in a supported format, or uses an unsupported algorithm or curve.
+	ErrUnsupportedKey = errors.New("unsupported key")
 )
 
+// TokenNotFoundError is returned by Storers when the RefreshToken identified by ID doesn't exist.
+// It wraps ErrTokenNotFound, so errors.Is can still be used to check for it.
+type TokenNotFoundError struct {
+	ID string
+}
+
+// Error describes ErrTokenNotFound, including the ID of the RefreshToken.
+func (e TokenNotFoundError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrTokenNotFound, e.ID)
+}
+
+// Unwrap returns ErrTokenNotFound.
+func (TokenNotFoundError) Unwrap() error {
+	return ErrTokenNotFound
+}
+
+// TokenRevokedError is returned by Storers when the RefreshToken identified by ID has been
+// revoked. It wraps ErrTokenRevoked, so errors.Is can still be used to check for it.
+type TokenRevokedError struct {
+	ID string
+}
+
+// Error describes ErrTokenRevoked, including the ID of the RefreshToken.
+func (e TokenRevokedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrTokenRevoked, e.ID)
+}
+
+// Unwrap returns ErrTokenRevoked.
+func (TokenRevokedError) Unwrap() error {
+	return ErrTokenRevoked
+}
+
+// TokenUsedError is returned by Storers when the RefreshToken identified by ID has already been
+// used. It wraps ErrTokenUsed, so errors.Is can still be used to check for it.
+type TokenUsedError struct {
+	ID string
+}
+
+// Error describes ErrTokenUsed, including the ID of the RefreshToken.
+func (e TokenUsedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrTokenUsed, e.ID)
+}
+
+// Unwrap returns ErrTokenUsed.
+func (TokenUsedError) Unwrap() error {
+	return ErrTokenUsed
+}
+
+// SigningMethodError is returned by Validate when a JWT claims a signing algorithm that isn't
+// one of Dependencies.SigningAlgorithms. It matches both ErrUnexpectedSigningMethod and
+// ErrInvalidToken with errors.Is, so callers treating invalid tokens as unauthorized still do.
+type SigningMethodError struct {
+	Algorithm interface{}
+}
+
+// Error describes ErrUnexpectedSigningMethod, including the algorithm the JWT claimed.
+func (e SigningMethodError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrUnexpectedSigningMethod, e.Algorithm)
+}
+
+// Is returns true if `target` is ErrUnexpectedSigningMethod or ErrInvalidToken.
+func (SigningMethodError) Is(target error) bool {
+	return target == ErrUnexpectedSigningMethod || target == ErrInvalidToken //nolint:errorlint,goerr113 // comparing sentinels is what Is is for
+}
+
+// ValidationHookError is returned by Validate and ValidateClaims when Dependencies.ValidationHook
+// rejects a RefreshToken. It wraps the hook's error, and also matches ErrInvalidToken with
+// errors.Is, as the token has been rejected.
+type ValidationHookError struct {
+	Err error
+}
+
+// Error describes the error returned by the ValidationHook.
+func (e ValidationHookError) Error() string {
+	return fmt.Sprintf("rejected by validation hook: %s", e.Err)
+}
+
+// Unwrap returns the error returned by the ValidationHook.
+func (e ValidationHookError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if `target` is ErrInvalidToken.
+func (ValidationHookError) Is(target error) bool {
+	return target == ErrInvalidToken //nolint:errorlint,goerr113 // comparing sentinels is what Is is for
+}
+
+// DefaultSigningAlgorithms are the JWT "alg" header values Validate accepts when
+// Dependencies.SigningAlgorithms isn't set.
+var DefaultSigningAlgorithms = []string{"RS256", "RS384", "RS512"}
+
 // RefreshToken represents a refresh token that can be used to obtain a new access token.
+//
+// CreatedIP and CreatedUserAgent are optional metadata about the request that created the
+// RefreshToken, kept for auditing. CreatedIP must be a single IPv4 or IPv6 address, not a list
+// like an X-Forwarded-For header. They are never included in the JWT issued for the RefreshToken.
+//
+// FamilyID identifies the chain of RefreshTokens created by rotating a RefreshToken, and is the ID
+// of the first RefreshToken in the chain, so one stolen RefreshToken can be used to revoke all of them.
 type RefreshToken struct {
-	ID          string
-	CreatedAt   time.Time
-	CreatedFrom string
-	Scopes      []string
-	AccountID   string
-	ProfileID   string
-	ClientID    string
-	Revoked     bool
-	Used        bool
+	ID               string
+	CreatedAt        time.Time
+	CreatedFrom      string
+	CreatedIP        string
+	CreatedUserAgent string
+	Scopes           []string
+	AccountID        string
+	ProfileID        string
+	ClientID         string
+	FamilyID         string
+	Revoked          bool
+	Used             bool
 }
 
 // RefreshTokenChange represents a change to one or more RefreshTokens. If ID is set, only the RefreshToken
 // specified by that ID will be changed. If ProfileID is set, all Tokens with a matching ProfileID property
 // will be changed. If ClientID is set, all Tokens with a matching ClientID property will be changed.
 //
-// Revoked and Used specify the new values for the RefreshToken(s)' Revoked or Used properties. If nil,
-// the property won't be updated.
+// Revoked, Used, Scopes, and CreatedAt specify the new values for the RefreshToken(s)' properties of the
+// same name. If nil, the property won't be updated. Setting Scopes to an empty slice removes all the
+// RefreshToken(s)' scopes. Storers don't check the new Scopes or CreatedAt against the limits configured
+// on Dependencies; use Dependencies.ValidateChange for that.
 type RefreshTokenChange struct {
 	ID        string
 	AccountID string
 	ProfileID string
 	ClientID  string
 
-	Revoked *bool
-	Used    *bool
+	Revoked   *bool
+	Used      *bool
+	Scopes    *[]string
+	CreatedAt *time.Time
 }
 
 // IsEmpty returns true if the RefreshTokenChange would not update any property on the matching RefreshTokens.
 func (r RefreshTokenChange) IsEmpty() bool {
-	return r.Revoked == nil && r.Used == nil
+	return r.Revoked == nil && r.Used == nil && r.Scopes == nil && r.CreatedAt == nil
 }
 
 // HasFilter returns true if one of the fields of `r` that is used to filter which tokens to apply the change
@@ -95,8 +286,9 @@ func (r RefreshTokenChange) HasFilter() bool {
 	return false
 }
 
-// ApplyChange updates the properties on `t` as specified by `change`. It does not check that `t` would be
-// matched by the ID, ProfileID, or ClientID properties of `change`.
+// ApplyChange returns a copy of `t` with its properties updated as specified by `change`, leaving `t` and
+// `change` unmodified. It does not check that `t` would be matched by the ID, ProfileID, or ClientID
+// properties of `change`. Like the Storers, it leaves a RefreshToken without scopes with nil Scopes.
 func ApplyChange(t RefreshToken, change RefreshTokenChange) RefreshToken {
 	result := t
 	if change.Revoked != nil {
@@ -105,11 +297,21 @@ func ApplyChange(t RefreshToken, change RefreshTokenChange) RefreshToken {
 	if change.Used != nil {
 		result.Used = *change.Used
 	}
+	if change.Scopes != nil {
+		result.Scopes = nil
+		if len(*change.Scopes) > 0 {
+			result.Scopes = append([]string(nil), *change.Scopes...)
+		}
+	}
+	if change.CreatedAt != nil {
+		result.CreatedAt = *change.CreatedAt
+	}
 	return result
 }
 
 // FillTokenDefaults returns a copy of `token` with all empty properties that have default values, like ID
-// and CreatedAt set to their default values.
+// and CreatedAt set to their default values. A RefreshToken without a FamilyID starts a new family, with
+// its own ID as the FamilyID.
 func FillTokenDefaults(token RefreshToken) (RefreshToken, error) {
 	res := token
 	if res.ID == "" {
@@ -119,22 +321,446 @@ func FillTokenDefaults(token RefreshToken) (RefreshToken, error) {
 		}
 		res.ID = id
 	}
+	if res.FamilyID == "" {
+		res.FamilyID = res.ID
+	}
 	if res.CreatedAt.IsZero() {
 		res.CreatedAt = time.Now()
 	}
 	return res, nil
 }
 
-// Dependencies manages the dependency injection for the tokens package. All its properties are required for
-// a Dependencies struct to be valid.
+// ValidateToken checks that `token` is safe to store and issue a JWT for,
+// returning an error describing the problem if it isn't.
+func ValidateToken(token RefreshToken) error {
+	if strings.Contains(token.ID, ".") {
+		return fmt.Errorf("%w: %q contains %q", ErrInvalidTokenID, token.ID, ".")
+	}
+	if token.CreatedAt.After(time.Now().Add(MaxCreatedAtSkew)) {
+		return fmt.Errorf("%w: %s", ErrTokenCreatedInFuture, token.CreatedAt)
+	}
+	if token.CreatedIP != "" && net.ParseIP(token.CreatedIP) == nil {
+		return fmt.Errorf("%w: %q", ErrInvalidCreatedIP, token.CreatedIP)
+	}
+	return nil
+}
+
+// Dependencies manages the dependency injection for the tokens package. Storer, JWTPrivateKey, JWTPublicKey,
+// and ServiceID are required for a Dependencies struct to be valid; the other properties are optional
+// configuration.
 type Dependencies struct {
 	Storer        Storer // Storer is the Storer to use when retrieving, setting, or removing RefreshTokens.
 	JWTPrivateKey *rsa.PrivateKey
 	JWTPublicKey  *rsa.PublicKey
 	ServiceID     string
+
+	// KeySet is used to look up the public keys to verify JWTs with in Validate. If nil, JWTPublicKey
+	// is the only key JWTs are verified with, and it is required.
+	KeySet KeySet
+
+	// Signer is used to sign the JWTs created by CreateJWT. If nil, JWTPrivateKey is used to sign
+	// them, identified by the fingerprint of JWTPublicKey, and JWTPrivateKey is required.
+	Signer Signer
+
+	// MaxScopes is the maximum number of scopes a RefreshToken can be created with. If 0,
+	// DefaultMaxScopes is used.
+	MaxScopes int
+
+	// MaxScopesLength is the maximum combined length, in bytes, of the scopes a RefreshToken can be
+	// created with. If 0, DefaultMaxScopesLength is used.
+	MaxScopesLength int
+
+	// MaxJWTLength is the longest token string, in bytes, that will be parsed when validating
+	// tokens. Longer strings are rejected with ErrInvalidToken without being parsed. If 0,
+	// DefaultMaxJWTLength is used.
+	MaxJWTLength int
+
+	// SigningAlgorithms are the JWT "alg" header values Validate accepts. JWTs claiming any other
+	// algorithm, including "none", are rejected with a SigningMethodError, which matches both
+	// ErrUnexpectedSigningMethod and ErrInvalidToken, before their signatures are checked, so
+	// they can't be used for algorithm confusion attacks. Only
+	// asymmetric algorithms should be listed, as the keys JWTs are verified with are public. If
+	// empty, DefaultSigningAlgorithms is used.
+	SigningAlgorithms []string
+
+	// Audiences are the audiences Validate accepts JWTs for. JWTs are only accepted when at
+	// least one of their audiences is in Audiences, so a JWT can be valid for multiple
+	// audiences, like a client and a resource it shares with other clients. If empty, JWTs
+	// are accepted regardless of their audience.
+	Audiences []string
+
+	// ExpiryJitter is the window before the end of a RefreshToken's lifetime its JWTs can expire
+	// in, so RefreshTokens created together don't all need refreshing at once. Each RefreshToken's
+	// JWTs expire at a point in the window picked based on its ID, so reissuing them doesn't
+	// change when they expire. Jitter only ever shortens a RefreshToken's lifetime. If 0, JWTs
+	// expire at the end of the RefreshToken's lifetime.
+	ExpiryJitter time.Duration
+
+	// TokenType is the value of the token_use claim CreateJWT includes in JWTs, so resource
+	// servers can tell them apart from access tokens. If empty, DefaultTokenType is used.
+	TokenType string
+
+	// RequireTokenType, when true, makes Validate reject JWTs whose token_use claim doesn't
+	// match TokenType with ErrInvalidToken, including JWTs without one.
+	RequireTokenType bool
+
+	// MinCreatedAt is the earliest CreatedAt a RefreshToken can be created with, to guard against
+	// backdated tokens. If zero, RefreshTokens can be created with any CreatedAt.
+	MinCreatedAt time.Time
+
+	// MinRSAKeyBits is the smallest RSA key, in bits, that CheckKeys accepts, and that JWTs can be
+	// verified with, using JWTPublicKey or a key from KeySet. If 0, DefaultMinRSAKeyBits is used.
+	// It doesn't apply to NewRSASigner or ParseSignerFromPEM, which always reject keys smaller
+	// than DefaultMinRSAKeyBits, so it can only be used to require larger keys.
+	MinRSAKeyBits int
+
+	// RequireUUIDs, when true, prevents RefreshTokens from being created with a ProfileID,
+	// ClientID, or AccountID that isn't a UUID. Empty IDs aren't checked.
+	RequireUUIDs bool
+
+	// ScopeDelimiter is the delimiter used to join a RefreshToken's scopes into a single string,
+	// like the scope in an Introspection. RefreshTokens can't be created with scopes that contain
+	// it. If empty, DefaultScopeDelimiter is used.
+	ScopeDelimiter string
+
+	// GracePeriod is how long after a RefreshToken expires ValidateGraceful will still accept
+	// it. If 0, ValidateGraceful behaves like Validate.
+	GracePeriod time.Duration
+
+	// OnTokenReuse is called when RotateToken is asked to rotate a RefreshToken that has already
+	// been used, which may mean the RefreshToken was stolen. It's passed the RefreshToken and the
+	// number of times it has been presented again after being used, so a policy can decide when
+	// to revoke it and the tokens issued alongside it. It's called after the reuse attempt has
+	// been recorded with the Storer, and before RotateToken returns ErrTokenUsed. Validate,
+	// ValidateClaims, and Introspect only report used RefreshTokens, they don't record them. If
+	// nil, reuse attempts are only recorded.
+	OnTokenReuse func(ctx context.Context, token RefreshToken, attempts int)
+
+	// OnKeyValidated is called each time Validate or ValidateGraceful accepts a JWT, with the
+	// "kid" of the key it was signed by, so a metric can be kept of how many JWTs each key is
+	// still validating, to know when a rotated key can be retired. There are only as many kids
+	// as there are keys in the KeySet, so they're safe to use as a metric label. If nil, nothing
+	// is recorded.
+	OnKeyValidated func(ctx context.Context, kid string)
+
+	// RevocationList, if set, is checked for the ID of each JWT Validate is passed instead of the
+	// Storer, so RefreshTokens can be validated without a round trip to the Storer. Revoked
+	// RefreshTokens are rejected with ErrTokenRevoked, and others are accepted without checking
+	// whether they've been used; the RefreshToken returned only has the ID, ProfileID, ClientID,
+	// and CreatedAt the JWT carries. If the RevocationList is unavailable, the Storer is checked
+	// as usual.
+	RevocationList RevocationList
+
+	// ValidationHook is called by Validate and ValidateClaims with RefreshTokens that have passed
+	// every other check, so deployments can reject them based on their own policies, like
+	// rejecting tokens for clients that are in maintenance. If it returns an error, validation
+	// fails with a ValidationHookError wrapping it, which also matches ErrInvalidToken, so
+	// Introspect reports the token as inactive. If nil, no extra checks are made.
+	ValidationHook func(ctx context.Context, token RefreshToken) error
+
+	// IDGenerator generates the IDs of RefreshTokens created without one. The IDs it generates
+	// must be unique and URL-safe, as they're included in the JWTs issued for RefreshTokens, and
+	// must not contain ".", which separates the parts of token strings. If nil, random UUIDs are
+	// used.
+	IDGenerator func() (string, error)
+
+	// IDPrefix is prepended to the IDs generated for RefreshTokens created without one, like
+	// "acct_", so the service that created a RefreshToken can be told from its ID. It's not added
+	// to IDs that are passed in. It must not contain ".", which separates the parts of token
+	// strings, and generated IDs with it can't be longer than MaxTokenIDLength.
+	IDPrefix string
+
+	// DefaultScopes are the scopes given to RefreshTokens created without any. They're never
+	// added to RefreshTokens that have scopes.
+	DefaultScopes []string
+
+	// NormalizeScope, if set, is applied to each of a RefreshToken's scopes before it's stored,
+	// and to both sides of the comparison in HasScope, so scopes that only differ in ways it
+	// normalizes away match. strings.ToLower and strings.TrimSpace are common choices. If nil,
+	// scopes are stored and matched verbatim.
+	NormalizeScope func(scope string) string
+
+	// DuplicateScopes controls what happens when a RefreshToken is created with the same scope
+	// more than once. By default, duplicates are allowed and stored as-is.
+	DuplicateScopes DuplicateScopePolicy
+}
+
+// DuplicateScopePolicy controls what Dependencies does with RefreshTokens that have the same
+// scope more than once.
+type DuplicateScopePolicy int
+
+const (
+	// DuplicateScopesAllowed stores duplicated scopes as-is.
+	DuplicateScopesAllowed DuplicateScopePolicy = iota
+
+	// DuplicateScopesRemoved removes all but the first of each duplicated scope before the
+	// RefreshToken is stored.
+	DuplicateScopesRemoved
+
+	// DuplicateScopesRejected rejects RefreshTokens with duplicated scopes with an
+	// ErrInvalidScope error.
+	DuplicateScopesRejected
+)
+
+// FillTokenDefaults returns a copy of `token` with all empty properties that have default values
+// set to their default values, like the package-level FillTokenDefaults, but using the
+// IDGenerator, IDPrefix, and DefaultScopes configured on `d`.
+func (d Dependencies) FillTokenDefaults(token RefreshToken) (RefreshToken, error) {
+	if len(token.Scopes) < 1 && len(d.DefaultScopes) > 0 {
+		token.Scopes = append([]string(nil), d.DefaultScopes...)
+	}
+	if token.ID == "" && (d.IDGenerator != nil || d.IDPrefix != "") {
+		if strings.Contains(d.IDPrefix, ".") {
+			return RefreshToken{}, fmt.Errorf("%w: prefix %q contains %q", ErrInvalidTokenID, d.IDPrefix, ".")
+		}
+		generate := d.IDGenerator
+		if generate == nil {
+			generate = uuid.GenerateUUID
+		}
+		id, err := generate()
+		if err != nil {
+			return RefreshToken{}, err
+		}
+		if len(d.IDPrefix+id) > MaxTokenIDLength {
+			return RefreshToken{}, fmt.Errorf("%w: %q is longer than %d bytes", ErrInvalidTokenID, d.IDPrefix+id, MaxTokenIDLength)
+		}
+		token.ID = d.IDPrefix + id
+	}
+	return FillTokenDefaults(token)
+}
+
+// ValidateToken checks that `token` is safe to store and issue a JWT for,
+// using the package-level ValidateToken and the limits configured on `d`.
+func (d Dependencies) ValidateToken(token RefreshToken) error {
+	err := ValidateToken(token)
+	if err != nil {
+		return err
+	}
+	if d.RequireUUIDs {
+		for _, field := range []struct{ name, value string }{
+			{name: "ProfileID", value: token.ProfileID},
+			{name: "ClientID", value: token.ClientID},
+			{name: "AccountID", value: token.AccountID},
+		} {
+			if field.value == "" {
+				continue
+			}
+			if _, err := uuid.ParseUUID(field.value); err != nil {
+				return fmt.Errorf("%w: %s %q", ErrInvalidUUID, field.name, field.value)
+			}
+		}
+	}
+	if !d.MinCreatedAt.IsZero() && token.CreatedAt.Before(d.MinCreatedAt) {
+		return fmt.Errorf("%w: %s is before %s", ErrTokenCreatedBeforeEpoch, token.CreatedAt, d.MinCreatedAt)
+	}
+	return d.validateScopes(token.Scopes)
+}
+
+// ValidateChange checks that the Scopes and CreatedAt `change` sets are within the limits
+// configured on `d`, the same way ValidateToken checks them for new RefreshTokens. Storers
+// apply RefreshTokenChanges without checking them, so changes that don't come from a trusted
+// source should be passed to ValidateChange before they're passed to a Storer.
+func (d Dependencies) ValidateChange(change RefreshTokenChange) error {
+	if change.CreatedAt != nil {
+		if change.CreatedAt.After(time.Now().Add(MaxCreatedAtSkew)) {
+			return fmt.Errorf("%w: %s", ErrTokenCreatedInFuture, *change.CreatedAt)
+		}
+		if !d.MinCreatedAt.IsZero() && change.CreatedAt.Before(d.MinCreatedAt) {
+			return fmt.Errorf("%w: %s is before %s", ErrTokenCreatedBeforeEpoch, *change.CreatedAt, d.MinCreatedAt)
+		}
+	}
+	if change.Scopes != nil {
+		return d.validateScopes(*change.Scopes)
+	}
+	return nil
+}
+
+// validateScopes checks `scopes` against the MaxScopes, ScopeDelimiter, DuplicateScopes, and
+// MaxScopesLength configured on `d`.
+func (d Dependencies) validateScopes(scopes []string) error {
+	maxScopes := d.MaxScopes
+	if maxScopes == 0 {
+		maxScopes = DefaultMaxScopes
+	}
+	if len(scopes) > maxScopes {
+		return fmt.Errorf("%w: %d scopes, maximum is %d", ErrTooManyScopes, len(scopes), maxScopes)
+	}
+	delimiter := d.scopeDelimiter()
+	seen := make(map[string]struct{}, len(scopes))
+	for _, scope := range scopes {
+		if strings.Contains(scope, delimiter) {
+			return fmt.Errorf("%w: %q contains delimiter %q", ErrInvalidScope, scope, delimiter)
+		}
+		if _, ok := seen[scope]; ok && d.DuplicateScopes == DuplicateScopesRejected {
+			return fmt.Errorf("%w: %q is duplicated", ErrInvalidScope, scope)
+		}
+		seen[scope] = struct{}{}
+	}
+	maxLength := d.MaxScopesLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxScopesLength
+	}
+	var length int
+	for _, scope := range scopes {
+		length += len(scope)
+	}
+	if length > maxLength {
+		return fmt.Errorf("%w: %d bytes, maximum is %d", ErrScopesTooLong, length, maxLength)
+	}
+	return nil
 }
 
-func getPublicKeyFingerprint(pk *rsa.PublicKey) (string, error) {
+func (d Dependencies) scopeDelimiter() string {
+	if d.ScopeDelimiter == "" {
+		return DefaultScopeDelimiter
+	}
+	return d.ScopeDelimiter
+}
+
+// CreateToken fills in the default values for `token`, normalizes its
+// scopes using d.NormalizeScope, removes duplicated scopes if
+// d.DuplicateScopes says to, checks that it's valid using
+// ValidateToken, and stores it in `d.Storer`. The RefreshToken that was
+// stored is returned.
+func (d Dependencies) CreateToken(ctx context.Context, token RefreshToken) (RefreshToken, error) {
+	token, err := d.prepareToken(token)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	err = d.Storer.CreateToken(ctx, token)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// prepareToken fills in, normalizes, and validates `token` the way
+// CreateToken does, without storing it.
+func (d Dependencies) prepareToken(token RefreshToken) (RefreshToken, error) {
+	token, err := d.FillTokenDefaults(token)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	token.Scopes = d.normalizeScopes(token.Scopes)
+	if d.DuplicateScopes == DuplicateScopesRemoved {
+		token.Scopes = dedupeScopes(token.Scopes)
+	}
+	err = d.ValidateToken(token)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+func (d Dependencies) normalizeScopes(scopes []string) []string {
+	if d.NormalizeScope == nil || scopes == nil {
+		return scopes
+	}
+	res := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		res = append(res, d.NormalizeScope(scope))
+	}
+	return res
+}
+
+// dedupeScopes returns `scopes` without any repeats, keeping the first of
+// each scope in its original position.
+func dedupeScopes(scopes []string) []string {
+	if scopes == nil {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(scopes))
+	res := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if _, ok := seen[scope]; ok {
+			continue
+		}
+		seen[scope] = struct{}{}
+		res = append(res, scope)
+	}
+	return res
+}
+
+// HasScope returns true if `token` has `scope` among its scopes, after
+// normalizing both using d.NormalizeScope.
+func (d Dependencies) HasScope(token RefreshToken, scope string) bool {
+	if d.NormalizeScope != nil {
+		scope = d.NormalizeScope(scope)
+	}
+	for _, tokenScope := range d.normalizeScopes(token.Scopes) {
+		if tokenScope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RotateToken exchanges `token`, which should have been returned by Validate, for a new
+// RefreshToken with the same scopes, account, profile, client, and family. `token` is marked as
+// used, so it can't be rotated again; rotating a used RefreshToken again is recorded as a reuse
+// attempt and ErrTokenUsed is returned. The new RefreshToken is validated before `token` is used,
+// and if d.Storer is a TxStorer, both changes are made in a single transaction. Otherwise, if
+// storing the new RefreshToken fails, `token` is marked as unused again, so the client isn't
+// left without a usable token. The new RefreshToken is returned.
+func (d Dependencies) RotateToken(ctx context.Context, token RefreshToken) (RefreshToken, error) {
+	familyID := token.FamilyID
+	if familyID == "" {
+		familyID = token.ID
+	}
+	next, err := d.prepareToken(RefreshToken{
+		CreatedFrom: token.CreatedFrom,
+		Scopes:      token.Scopes,
+		AccountID:   token.AccountID,
+		ProfileID:   token.ProfileID,
+		ClientID:    token.ClientID,
+		FamilyID:    familyID,
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	rotate := func(storer Storer) error {
+		err := storer.UseToken(ctx, token.ID)
+		if err != nil {
+			return err
+		}
+		return storer.CreateToken(ctx, next)
+	}
+	err = ErrTransactionsUnsupported
+	if txStorer, ok := d.Storer.(TxStorer); ok {
+		err = txStorer.WithTransaction(ctx, rotate)
+	}
+	if errors.Is(err, ErrTransactionsUnsupported) {
+		err = d.rotateWithoutTx(ctx, token, next)
+	}
+	if errors.Is(err, ErrTokenUsed) {
+		d.recordReuseAttempt(ctx, token)
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return next, nil
+}
+
+// rotateWithoutTx marks `token` as used and stores `next` for RotateToken when d.Storer can't
+// do both in a transaction. If storing `next` fails, `token` is marked as unused again.
+func (d Dependencies) rotateWithoutTx(ctx context.Context, token, next RefreshToken) error {
+	err := d.Storer.UseToken(ctx, token.ID)
+	if err != nil {
+		return err
+	}
+	err = d.Storer.CreateToken(ctx, next)
+	if err == nil {
+		return nil
+	}
+	unused := false
+	_, undoErr := d.Storer.UpdateTokens(ctx, RefreshTokenChange{ID: token.ID, Used: &unused})
+	if undoErr != nil {
+		yall.FromContext(ctx).WithField("id", token.ID).WithError(undoErr).Error("error marking token unused after failed rotation")
+	}
+	return err
+}
+
+func getPublicKeyFingerprint(pk crypto.PublicKey) (string, error) {
 	p, err := ssh.NewPublicKey(pk)
 	if err != nil {
 		return "", fmt.Errorf("Error creating SSH public key: %w", err)
@@ -143,31 +769,176 @@ func getPublicKeyFingerprint(pk *rsa.PublicKey) (string, error) {
 	return fingerprint, nil
 }
 
+func (d Dependencies) keySet() (KeySet, error) { //nolint:ireturn // returns whichever KeySet is configured
+	if d.KeySet != nil {
+		return d.KeySet, nil
+	}
+	return NewPublicKeys(d.JWTPublicKey)
+}
+
 // Validate checks that the token with the given ID has the given value, and returns an
-// ErrInvalidToken if not.
+// ErrInvalidToken if not. If the token is otherwise valid but has expired, ErrTokenExpired is
+// returned instead.
 func (d Dependencies) Validate(ctx context.Context, jwtVal string) (RefreshToken, error) {
-	tok, err := jwt.Parse(jwtVal, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+	token, _, err := d.validate(ctx, jwtVal, 0)
+	return token, err
+}
+
+// ValidateGraceful checks the token like Validate, but accepts tokens that expired less than
+// d.GracePeriod ago, so clients can still refresh them. The boolean returned is true when the
+// token has expired but is within the grace period, and should be replaced soon. Tokens that
+// expired longer than d.GracePeriod ago are rejected with ErrTokenExpired.
+func (d Dependencies) ValidateGraceful(ctx context.Context, jwtVal string) (RefreshToken, bool, error) {
+	return d.validate(ctx, jwtVal, d.GracePeriod)
+}
+
+// validate checks `jwtVal` for Validate, ValidateGraceful, and Introspect, accepting tokens that
+// expired less than `grace` ago.
+func (d Dependencies) validate(ctx context.Context, jwtVal string, grace time.Duration) (RefreshToken, bool, error) {
+	maxLength := d.MaxJWTLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxJWTLength
+	}
+	if len(jwtVal) > maxLength {
+		yall.FromContext(ctx).WithField("length", len(jwtVal)).Debug("Token too long to validate.")
+		return RefreshToken{}, false, ErrInvalidToken
+	}
+	unverified, _, err := jwt.NewParser().ParseUnverified(jwtVal, &jwt.RegisteredClaims{})
+	if err != nil {
+		yall.FromContext(ctx).WithError(err).Debug("Error parsing token.")
+		return RefreshToken{}, false, ErrInvalidToken
+	}
+	if !d.allowedSigningAlgorithm(unverified.Header["alg"]) {
+		return RefreshToken{}, false, SigningMethodError{Algorithm: unverified.Header["alg"]}
+	}
+	keys, err := d.keySet()
+	if err != nil {
+		return RefreshToken{}, false, err
+	}
+	tok, err := jwt.ParseWithClaims(jwtVal, &jwtClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if !d.allowedSigningAlgorithm(token.Header["alg"]) {
 			return nil, fmt.Errorf("%w: %v", ErrUnexpectedSigningMethod, token.Header["alg"])
 		}
-		fp, err := getPublicKeyFingerprint(d.JWTPublicKey)
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownSigningKey, token.Header["kid"])
+		}
+		key, err := keys.KeyForID(kid)
 		if err != nil {
 			return nil, err
 		}
-		if fp != token.Header["kid"] {
-			return nil, fmt.Errorf("%w: %v", ErrUnknownSigningKey, token.Header["kid"])
+		err = d.checkRSAKey("key "+kid, key)
+		if err != nil {
+			return nil, err
 		}
-		return d.JWTPublicKey, nil
+		return key, nil
 	})
+	var inGrace bool
 	if err != nil {
 		yall.FromContext(ctx).WithError(err).Debug("Error validating token.")
-		return RefreshToken{}, ErrInvalidToken
+		var vErr *jwt.ValidationError
+		if !errors.As(err, &vErr) || vErr.Errors != jwt.ValidationErrorExpired {
+			return RefreshToken{}, false, ErrInvalidToken
+		}
+		claims, ok := tok.Claims.(*jwtClaims)
+		if !ok || claims.ExpiresAt == nil || !time.Now().Before(claims.ExpiresAt.Add(grace)) {
+			return RefreshToken{}, false, ErrTokenExpired
+		}
+		inGrace = true
 	}
-	claims, ok := tok.Claims.(*jwt.RegisteredClaims)
+	claims, ok := tok.Claims.(*jwtClaims)
 	if !ok {
+		return RefreshToken{}, false, ErrInvalidToken
+	}
+	if !d.allowedAudience(claims.Audience) {
+		yall.FromContext(ctx).WithField("audience", strings.Join(claims.Audience, ",")).Debug("Token not issued for an accepted audience.")
+		return RefreshToken{}, false, ErrInvalidToken
+	}
+	if d.RequireTokenType && claims.TokenUse != d.tokenType() {
+		yall.FromContext(ctx).WithField("token_use", claims.TokenUse).Debug("Token has the wrong token type.")
+		return RefreshToken{}, false, ErrInvalidToken
+	}
+	token, err := d.ValidateClaims(ctx, &claims.RegisteredClaims)
+	if err != nil {
+		return RefreshToken{}, false, err
+	}
+	if d.OnKeyValidated != nil {
+		kid, _ := tok.Header["kid"].(string)
+		d.OnKeyValidated(ctx, kid)
+	}
+	return token, inGrace, nil
+}
+
+// allowedSigningAlgorithm returns true if `alg`, a JWT "alg" header value, is one of the
+// SigningAlgorithms configured on `d`.
+func (d Dependencies) allowedSigningAlgorithm(alg interface{}) bool {
+	algs := d.SigningAlgorithms
+	if len(algs) < 1 {
+		algs = DefaultSigningAlgorithms
+	}
+	for _, allowed := range algs {
+		if alg == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// jwtClaims are the claims in the JWTs CreateJWT issues.
+type jwtClaims struct {
+	jwt.RegisteredClaims
+
+	// TokenUse is the type of token the JWT is, so it can be told apart
+	// from access tokens.
+	TokenUse string `json:"token_use,omitempty"`
+}
+
+// tokenType returns the TokenType configured on `d`, or DefaultTokenType if none is.
+func (d Dependencies) tokenType() string {
+	if d.TokenType == "" {
+		return DefaultTokenType
+	}
+	return d.TokenType
+}
+
+// allowedAudience returns true if any of `audiences` is one of the Audiences configured on `d`,
+// or if no Audiences are configured.
+func (d Dependencies) allowedAudience(audiences jwt.ClaimStrings) bool {
+	if len(d.Audiences) < 1 {
+		return true
+	}
+	for _, aud := range audiences {
+		for _, allowed := range d.Audiences {
+			if aud == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateClaims checks that the token `claims` were issued for exists and hasn't been revoked
+// or used, without parsing a JWT. If d.RevocationList is set and available, only it is checked.
+// It's meant for callers that have already parsed the JWT, and doesn't check the JWT's
+// signature or expiration; callers are responsible for verifying those before calling
+// ValidateClaims. Validate should be used instead whenever that isn't the case.
+func (d Dependencies) ValidateClaims(ctx context.Context, claims *jwt.RegisteredClaims) (RefreshToken, error) {
+	if claims == nil || claims.ID == "" {
 		return RefreshToken{}, ErrInvalidToken
 	}
 	log := yall.FromContext(ctx).WithField("id", claims.ID)
+	if d.RevocationList != nil {
+		revoked, err := d.RevocationList.Revoked(ctx, claims.ID)
+		switch {
+		case err != nil:
+			log.WithError(err).Warn("error checking revocation list, falling back to storer")
+		case revoked:
+			log.Debug("token on revocation list presented")
+			return RefreshToken{}, ErrTokenRevoked
+		default:
+			return d.checkValidationHook(ctx, tokenFromClaims(claims))
+		}
+	}
 	token, err := d.Storer.GetToken(ctx, claims.ID)
 	if errors.Is(err, ErrTokenNotFound) {
 		return RefreshToken{}, ErrInvalidToken
@@ -183,25 +954,224 @@ func (d Dependencies) Validate(ctx context.Context, jwtVal string) (RefreshToken
 		log.Debug("used token presented")
 		return RefreshToken{}, ErrTokenUsed
 	}
+	return d.checkValidationHook(ctx, token)
+}
+
+// checkValidationHook passes `token` to d.ValidationHook, if it's set, returning a
+// ValidationHookError if it's rejected.
+func (d Dependencies) checkValidationHook(ctx context.Context, token RefreshToken) (RefreshToken, error) {
+	if d.ValidationHook == nil {
+		return token, nil
+	}
+	err := d.ValidationHook(ctx, token)
+	if err != nil {
+		yall.FromContext(ctx).WithField("id", token.ID).WithError(err).Debug("token rejected by validation hook")
+		return RefreshToken{}, ValidationHookError{Err: err}
+	}
 	return token, nil
 }
 
-// CreateJWT returns a signed JWT for `token`, using the private key set in
-// `d.JWTPrivateKey` as the private key to sign with.
-func (d Dependencies) CreateJWT(_ context.Context, token RefreshToken) (string, error) {
-	res := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.RegisteredClaims{
-		Audience:  jwt.ClaimStrings{token.ClientID},
-		ExpiresAt: jwt.NewNumericDate(token.CreatedAt.UTC().Add(refreshLength)),
-		ID:        token.ID,
-		IssuedAt:  jwt.NewNumericDate(token.CreatedAt.UTC()),
-		Issuer:    d.ServiceID,
-		NotBefore: jwt.NewNumericDate(token.CreatedAt.UTC().Add(-1 * time.Hour)),
-		Subject:   token.ProfileID,
-	})
+// tokenFromClaims builds a RefreshToken from what CreateJWT put in `claims`, for when the
+// Storer isn't consulted. Only the ID, ProfileID, ClientID, and CreatedAt are set.
+func tokenFromClaims(claims *jwt.RegisteredClaims) RefreshToken {
+	token := RefreshToken{
+		ID:        claims.ID,
+		ProfileID: claims.Subject,
+	}
+	if len(claims.Audience) > 0 {
+		token.ClientID = claims.Audience[0]
+	}
+	if claims.IssuedAt != nil {
+		token.CreatedAt = claims.IssuedAt.Time
+	}
+	return token
+}
+
+// recordReuseAttempt records that `token` was presented for rotation again
+// after being used, and lets d.OnTokenReuse know. Failing to record the
+// attempt is logged, but doesn't change the outcome of the rotation.
+func (d Dependencies) recordReuseAttempt(ctx context.Context, token RefreshToken) {
+	attempts, err := d.Storer.MarkTokenReuseAttempt(ctx, token.ID)
+	if err != nil {
+		yall.FromContext(ctx).WithField("id", token.ID).WithError(err).Error("error recording token reuse attempt")
+		return
+	}
+	if d.OnTokenReuse != nil {
+		d.OnTokenReuse(ctx, token, attempts)
+	}
+}
+
+// expiresAt returns when the JWTs issued for `token` expire. With ExpiryJitter set, that's moved
+// earlier by an amount derived from the RefreshToken's ID, so it's the same every time it's
+// computed for a RefreshToken, but spread out across RefreshTokens.
+func (d Dependencies) expiresAt(token RefreshToken) time.Time {
+	exp := token.CreatedAt.UTC().Add(refreshLength)
+	window := d.ExpiryJitter
+	if window > refreshLength {
+		window = refreshLength
+	}
+	if window <= 0 {
+		return exp
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(token.ID))
+	return exp.Add(-1 * time.Duration(hash.Sum64()%uint64(window)))
+}
+
+// CheckKeys returns an error wrapping ErrRSAKeyTooSmall if JWTPrivateKey, JWTPublicKey, or the
+// key of an RSASigner set as Signer is smaller than d.MinRSAKeyBits. It should be called when
+// the Dependencies are set up, so a misconfigured key stops the service from starting, instead of
+// being found the first time a JWT is signed. CreateJWT checks the keys again before signing.
+// Keys that aren't set aren't checked, and keys from KeySet are checked as they're used to
+// verify JWTs, as they may change.
+func (d Dependencies) CheckKeys() error {
+	if d.JWTPrivateKey != nil {
+		if err := d.checkRSAKey("private key", &d.JWTPrivateKey.PublicKey); err != nil {
+			return err
+		}
+	}
+	if d.JWTPublicKey != nil {
+		if err := d.checkRSAKey("public key", d.JWTPublicKey); err != nil {
+			return err
+		}
+	}
+	if signer, ok := d.Signer.(RSASigner); ok {
+		if err := d.checkRSAKey("signer key", &signer.key.PublicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRSAKey returns an error wrapping ErrRSAKeyTooSmall if `key` is an RSA key smaller than
+// d.MinRSAKeyBits, describing it as `name`. Other kinds of keys aren't checked.
+func (d Dependencies) checkRSAKey(name string, key crypto.PublicKey) error {
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil
+	}
+	minBits := d.MinRSAKeyBits
+	if minBits == 0 {
+		minBits = DefaultMinRSAKeyBits
+	}
+	if rsaKey.N.BitLen() < minBits {
+		return fmt.Errorf("%w: %s is %d bits, minimum is %d", ErrRSAKeyTooSmall, name, rsaKey.N.BitLen(), minBits)
+	}
+	return nil
+}
+
+func (d Dependencies) signer() (Signer, error) { //nolint:ireturn // returns whichever Signer is configured
+	err := d.CheckKeys()
+	if err != nil {
+		return nil, err
+	}
+	if d.Signer != nil {
+		return d.Signer, nil
+	}
 	fp, err := getPublicKeyFingerprint(d.JWTPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return RSASigner{key: d.JWTPrivateKey, kid: fp}, nil
+}
+
+// CreateJWT returns a signed JWT for `token`, using `d.Signer` to sign it.
+// If `d.Signer` isn't set, the private key set in `d.JWTPrivateKey` is used
+// as the private key to sign with.
+func (d Dependencies) CreateJWT(ctx context.Context, token RefreshToken) (string, error) {
+	signer, err := d.signer()
+	if err != nil {
+		return "", err
+	}
+	method := jwt.GetSigningMethod(signer.Algorithm())
+	if method == nil {
+		return "", fmt.Errorf("%w: %v", ErrUnexpectedSigningMethod, signer.Algorithm())
+	}
+	res := jwt.NewWithClaims(method, &jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{token.ClientID},
+			ExpiresAt: jwt.NewNumericDate(d.expiresAt(token)),
+			ID:        token.ID,
+			IssuedAt:  jwt.NewNumericDate(token.CreatedAt.UTC()),
+			Issuer:    d.ServiceID,
+			NotBefore: jwt.NewNumericDate(token.CreatedAt.UTC().Add(-1 * time.Hour)),
+			Subject:   token.ProfileID,
+		},
+		TokenUse: d.tokenType(),
+	})
+	res.Header["kid"] = signer.KeyID()
+	signingString, err := res.SigningString()
+	if err != nil {
+		return "", err
+	}
+	sig, err := signer.Sign(ctx, []byte(signingString))
+	if err != nil {
+		return "", err
+	}
+	return signingString + "." + jwt.EncodeSegment(sig), nil
+}
+
+// ReSignToken signs a new JWT for the stored `token`, using the current signing key, so JWTs
+// signed by a key that's being rotated out can be replaced before it's retired, or after. The new
+// JWT has the same claims as the ones issued for `token` before, as its ID and CreatedAt aren't
+// changed. No JWT is needed, so tokens can be migrated even once their old key is gone from the
+// KeySet. It's ReissueJWT for callers holding the RefreshToken, like when iterating over a
+// profile's RefreshTokens: the stored copy is what's re-signed, so a stale `token` can't
+// resurrect one that has since been revoked or used.
+func (d Dependencies) ReSignToken(ctx context.Context, token RefreshToken) (string, error) {
+	return d.ReissueJWT(ctx, token.ID)
+}
+
+// ReissueJWT signs a new JWT for the stored RefreshToken with the ID `id`, for callers that have
+// lost the JWT issued when it was created. The RefreshToken must still be live: an
+// ErrTokenRevoked or ErrTokenUsed is returned if it has been revoked or used, and ErrTokenExpired
+// if it has expired.
+func (d Dependencies) ReissueJWT(ctx context.Context, id string) (string, error) {
+	token, err := d.Storer.GetToken(ctx, id)
 	if err != nil {
 		return "", err
 	}
-	res.Header["kid"] = fp
-	return res.SignedString(d.JWTPrivateKey)
+	if token.Revoked {
+		return "", ErrTokenRevoked
+	}
+	if token.Used {
+		return "", ErrTokenUsed
+	}
+	if !time.Now().Before(d.expiresAt(token)) {
+		return "", ErrTokenExpired
+	}
+	return d.CreateJWT(ctx, token)
+}
+
+// Introspection describes a token in the shape of an RFC 7662 token
+// introspection response.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// Introspect validates `jwtVal` and describes the RefreshToken it
+// represents as an Introspection. Tokens that fail validation because
+// they're invalid, expired, revoked, or used are reported as inactive,
+// not as an error; an error is only returned if the token couldn't be
+// checked.
+func (d Dependencies) Introspect(ctx context.Context, jwtVal string) (Introspection, error) {
+	token, _, err := d.validate(ctx, jwtVal, 0)
+	if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenRevoked) || errors.Is(err, ErrTokenUsed) {
+		return Introspection{Active: false}, nil
+	} else if err != nil {
+		return Introspection{}, err
+	}
+	return Introspection{
+		Active:    true,
+		Scope:     strings.Join(token.Scopes, d.scopeDelimiter()),
+		ClientID:  token.ClientID,
+		Subject:   token.ProfileID,
+		ExpiresAt: d.expiresAt(token).Unix(),
+		IssuedAt:  token.CreatedAt.UTC().Unix(),
+	}, nil
 }
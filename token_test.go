@@ -0,0 +1,1656 @@
+package tokens_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/tokens"
+	"lockbox.dev/tokens/storers/deadline"
+	"lockbox.dev/tokens/storers/memory"
+)
+
+func newDependencies(t *testing.T) tokens.Dependencies {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gomnd // key size is arbitrary, not magic
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Unexpected error creating memory storer: %+v\n", err)
+	}
+	return tokens.Dependencies{
+		Storer:        storer,
+		JWTPrivateKey: key,
+		JWTPublicKey:  &key.PublicKey,
+		ServiceID:     "https://tokens.test.lockbox.dev",
+	}
+}
+
+func TestValidateTokenCreatedInFuture(t *testing.T) {
+	t.Parallel()
+
+	token, err := tokens.FillTokenDefaults(tokens.RefreshToken{
+		CreatedAt: time.Now().Add(24 * time.Hour * 365),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	err = tokens.ValidateToken(token)
+	if !errors.Is(err, tokens.ErrTokenCreatedInFuture) {
+		t.Errorf("Expected tokens.ErrTokenCreatedInFuture, got %+v\n", err)
+	}
+}
+
+func TestValidateTokenCreatedIP(t *testing.T) {
+	t.Parallel()
+
+	for ip, valid := range map[string]bool{
+		"":                      true,
+		"203.0.113.7":           true,
+		"2001:db8::1":           true,
+		"::ffff:203.0.113.7":    true,
+		"203.0.113.7, 10.0.0.1": false,
+		"fe80::1%eth0":          false,
+		"localhost":             false,
+	} {
+		err := tokens.ValidateToken(tokens.RefreshToken{ID: "token", CreatedAt: time.Now(), CreatedIP: ip})
+		if valid && err != nil {
+			t.Errorf("Unexpected error validating CreatedIP %q: %+v\n", ip, err)
+		} else if !valid && !errors.Is(err, tokens.ErrInvalidCreatedIP) {
+			t.Errorf("Expected tokens.ErrInvalidCreatedIP validating CreatedIP %q, got %+v\n", ip, err)
+		}
+	}
+}
+
+func TestValidateTokenCreatedWithinSkew(t *testing.T) {
+	t.Parallel()
+
+	for _, createdAt := range []time.Time{
+		time.Now().Add(-1 * time.Hour),
+		time.Now(),
+		time.Now().Add(tokens.MaxCreatedAtSkew / 2),
+	} {
+		token, err := tokens.FillTokenDefaults(tokens.RefreshToken{
+			CreatedAt: createdAt,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+		}
+		err = tokens.ValidateToken(token)
+		if err != nil {
+			t.Errorf("Unexpected error validating token created at %s: %+v\n", createdAt, err)
+		}
+	}
+}
+
+func TestCreateJWTOmitsCreationMetadata(t *testing.T) {
+	t.Parallel()
+
+	deps := newDependencies(t)
+	token, err := tokens.FillTokenDefaults(tokens.RefreshToken{
+		CreatedFrom:      "test case",
+		CreatedIP:        "203.0.113.7",
+		CreatedUserAgent: "lockbox-metadata-test/1.0",
+		ProfileID:        "profile",
+		ClientID:         "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	signed, err := deps.CreateJWT(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(signed, claims)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing JWT: %+v\n", err)
+	}
+	for claim, value := range claims {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if strings.Contains(str, token.CreatedIP) || strings.Contains(str, token.CreatedUserAgent) {
+			t.Errorf("Claim %q contains creation metadata: %q", claim, str)
+		}
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	active, err := tokens.FillTokenDefaults(tokens.RefreshToken{
+		CreatedAt:   time.Now().Add(-1 * time.Minute).Round(time.Second),
+		CreatedFrom: "test case",
+		Scopes:      []string{"https://scopes.impractical.co/profiles/view:me", "https://scopes.impractical.co/profiles/edit:me"},
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	revoked, err := tokens.FillTokenDefaults(tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+		Revoked:     true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	used, err := tokens.FillTokenDefaults(tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+		Used:        true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	expired, err := tokens.FillTokenDefaults(tokens.RefreshToken{
+		CreatedAt:   time.Now().Add(-24 * time.Hour * 365),
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+
+	jwts := map[string]string{}
+	for _, token := range []tokens.RefreshToken{active, revoked, used, expired} {
+		err := deps.Storer.CreateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		signed, err := deps.CreateJWT(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+		}
+		jwts[token.ID] = signed
+	}
+
+	result, err := deps.Introspect(ctx, jwts[active.ID])
+	if err != nil {
+		t.Fatalf("Unexpected error introspecting active token: %+v\n", err)
+	}
+	expected := tokens.Introspection{
+		Active:    true,
+		Scope:     "https://scopes.impractical.co/profiles/view:me https://scopes.impractical.co/profiles/edit:me",
+		ClientID:  active.ClientID,
+		Subject:   active.ProfileID,
+		ExpiresAt: active.CreatedAt.Add(24 * time.Hour * 14).Unix(),
+		IssuedAt:  active.CreatedAt.Unix(),
+	}
+	if result != expected {
+		t.Errorf("Expected %+v, got %+v\n", expected, result)
+	}
+
+	for name, signed := range map[string]string{
+		"revoked":   jwts[revoked.ID],
+		"used":      jwts[used.ID],
+		"expired":   jwts[expired.ID],
+		"malformed": "not a token",
+		"none":      resignWithAlgorithm(t, jwts[active.ID], jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+		"HS256":     resignWithAlgorithm(t, jwts[active.ID], jwt.SigningMethodHS256, publicKeyPEM(t, deps.JWTPublicKey)),
+	} {
+		result, err := deps.Introspect(ctx, signed)
+		if err != nil {
+			t.Errorf("Unexpected error introspecting %s token: %+v\n", name, err)
+		}
+		if result != (tokens.Introspection{Active: false}) {
+			t.Errorf("Expected %s token to be inactive, got %+v\n", name, result)
+		}
+	}
+}
+
+// resignWithAlgorithm returns a JWT with the same header and claims as
+// `jwtVal`, signed using `method` and `key` instead.
+func resignWithAlgorithm(t *testing.T, jwtVal string, method jwt.SigningMethod, key interface{}) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(jwtVal, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("Unexpected error parsing JWT: %+v\n", err)
+	}
+	resigned := jwt.NewWithClaims(method, parsed.Claims)
+	resigned.Header["kid"] = parsed.Header["kid"]
+	res, err := resigned.SignedString(key)
+	if err != nil {
+		t.Fatalf("Unexpected error signing JWT with %s: %+v\n", method.Alg(), err)
+	}
+	return res
+}
+
+// publicKeyPEM returns `key` PEM-encoded, the way it would be published
+// for verifying JWTs, for algorithm confusion tests.
+func publicKeyPEM(t *testing.T, key *rsa.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling public key: %+v\n", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestCreateTokenScopeLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	deps.MaxScopes = 3
+	deps.MaxScopesLength = 12
+
+	type testCase struct {
+		scopes []string
+		err    error
+	}
+	for pos, test := range []testCase{
+		{scopes: []string{"a", "b", "c"}},
+		{scopes: []string{"a", "b", "c", "d"}, err: tokens.ErrTooManyScopes},
+		{scopes: []string{"abcdef", "ghijkl"}},
+		{scopes: []string{"abcdef", "ghijklm"}, err: tokens.ErrScopesTooLong},
+	} {
+		_, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedFrom: "test case",
+			Scopes:      test.scopes,
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if !errors.Is(err, test.err) {
+			t.Errorf("Case %d: expected error %v, got %+v\n", pos, test.err, err)
+		}
+	}
+}
+
+func TestCreateTokenDefaultScopeLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	scopes := make([]string, 0, tokens.DefaultMaxScopes+1)
+	for i := 0; i < tokens.DefaultMaxScopes; i++ {
+		scopes = append(scopes, fmt.Sprintf("scope-%d", i))
+	}
+	_, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		Scopes:      scopes,
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Errorf("Unexpected error creating token with %d scopes: %+v\n", len(scopes), err)
+	}
+
+	scopes = append(scopes, "one-too-many")
+	_, err = deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		Scopes:      scopes,
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if !errors.Is(err, tokens.ErrTooManyScopes) {
+		t.Errorf("Expected tokens.ErrTooManyScopes, got %+v\n", err)
+	}
+}
+
+func TestCreateTokenMinCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	deps.MinCreatedAt = time.Now().Add(-1 * time.Hour).Round(time.Millisecond)
+
+	type testCase struct {
+		createdAt time.Time
+		err       error
+	}
+	for pos, test := range []testCase{
+		{createdAt: deps.MinCreatedAt.Add(-1 * time.Millisecond), err: tokens.ErrTokenCreatedBeforeEpoch},
+		{createdAt: deps.MinCreatedAt},
+		{createdAt: deps.MinCreatedAt.Add(time.Millisecond)},
+	} {
+		_, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedAt:   test.createdAt,
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if !errors.Is(err, test.err) {
+			t.Errorf("Case %d: expected error %v, got %+v\n", pos, test.err, err)
+		}
+	}
+}
+
+func TestCreateTokenNoMinCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	_, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedAt:   time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Errorf("Unexpected error creating backdated token without MinCreatedAt: %+v\n", err)
+	}
+}
+
+func TestValidateChange(t *testing.T) {
+	t.Parallel()
+
+	deps := newDependencies(t)
+	deps.MaxScopes = 3
+	deps.MaxScopesLength = 12
+	deps.MinCreatedAt = time.Now().Add(-1 * time.Hour).Round(time.Millisecond)
+
+	scopes := func(scopes ...string) *[]string { return &scopes }
+	at := func(when time.Time) *time.Time { return &when }
+	revoked := true
+
+	type testCase struct {
+		change tokens.RefreshTokenChange
+		err    error
+	}
+	for pos, test := range []testCase{
+		{change: tokens.RefreshTokenChange{Revoked: &revoked}},
+		{change: tokens.RefreshTokenChange{Scopes: scopes()}},
+		{change: tokens.RefreshTokenChange{Scopes: scopes("a", "b", "c")}},
+		{change: tokens.RefreshTokenChange{Scopes: scopes("a", "b", "c", "d")}, err: tokens.ErrTooManyScopes},
+		{change: tokens.RefreshTokenChange{Scopes: scopes("abcdef", "ghijklm")}, err: tokens.ErrScopesTooLong},
+		{change: tokens.RefreshTokenChange{Scopes: scopes("a b")}, err: tokens.ErrInvalidScope},
+		{change: tokens.RefreshTokenChange{CreatedAt: at(deps.MinCreatedAt)}},
+		{change: tokens.RefreshTokenChange{CreatedAt: at(deps.MinCreatedAt.Add(-1 * time.Millisecond))}, err: tokens.ErrTokenCreatedBeforeEpoch},
+		{change: tokens.RefreshTokenChange{CreatedAt: at(time.Now().Add(time.Hour))}, err: tokens.ErrTokenCreatedInFuture},
+	} {
+		err := deps.ValidateChange(test.change)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Case %d: expected error %v, got %+v\n", pos, test.err, err)
+		}
+	}
+}
+
+func TestValidateExpiredToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedAt:   time.Now().Add(-15 * 24 * time.Hour),
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+
+	_, err = deps.Validate(ctx, jwtVal)
+	if !errors.Is(err, tokens.ErrTokenExpired) {
+		t.Errorf("Expected tokens.ErrTokenExpired, got %+v\n", err)
+	}
+
+	// an expired token with a bad signature is still just invalid
+	_, err = deps.Validate(ctx, jwtVal[:len(jwtVal)-4]+"AAAA")
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken for tampered token, got %+v\n", err)
+	}
+
+	_, err = deps.Validate(ctx, "not a jwt")
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken for malformed token, got %+v\n", err)
+	}
+}
+
+func TestCreateTokenIDGenerator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	var generated int
+	deps.IDGenerator = func() (string, error) {
+		generated++
+		return fmt.Sprintf("custom-%d", generated), nil
+	}
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	if token.ID != "custom-1" {
+		t.Errorf("Expected ID %q, got %q", "custom-1", token.ID)
+	}
+	stored, err := deps.Storer.GetToken(ctx, "custom-1")
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+	}
+	if stored.ID != "custom-1" {
+		t.Errorf("Expected stored ID %q, got %q", "custom-1", stored.ID)
+	}
+
+	// IDs that are already set are left alone
+	token, err = deps.FillTokenDefaults(tokens.RefreshToken{ID: "preset"})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	if token.ID != "preset" || generated != 1 {
+		t.Errorf("Expected preset ID to be kept without generating one, got %q after %d generated", token.ID, generated)
+	}
+
+	errGenerator := errors.New("generator failed")
+	deps.IDGenerator = func() (string, error) { return "", errGenerator }
+	_, err = deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if !errors.Is(err, errGenerator) {
+		t.Errorf("Expected generator error, got %+v\n", err)
+	}
+}
+
+func TestCreateTokenIDPrefix(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	deps.IDPrefix = "acct_"
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	if !strings.HasPrefix(token.ID, "acct_") || len(token.ID) == len("acct_") {
+		t.Errorf("Expected generated ID with prefix %q, got %q", "acct_", token.ID)
+	}
+	stored, err := deps.Storer.GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, stored); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	validated, err := deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Fatalf("Unexpected error validating token: %+v\n", err)
+	}
+	if validated.ID != token.ID {
+		t.Errorf("Expected validated ID %q, got %q", token.ID, validated.ID)
+	}
+
+	deps.IDGenerator = func() (string, error) { return "custom", nil }
+	token, err = deps.FillTokenDefaults(tokens.RefreshToken{})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	if token.ID != "acct_custom" {
+		t.Errorf("Expected ID %q, got %q", "acct_custom", token.ID)
+	}
+
+	// IDs that are already set don't get the prefix
+	token, err = deps.FillTokenDefaults(tokens.RefreshToken{ID: "preset"})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults: %+v\n", err)
+	}
+	if token.ID != "preset" {
+		t.Errorf("Expected ID %q, got %q", "preset", token.ID)
+	}
+
+	deps.IDPrefix = "acct."
+	_, err = deps.FillTokenDefaults(tokens.RefreshToken{})
+	if !errors.Is(err, tokens.ErrInvalidTokenID) {
+		t.Errorf("Expected tokens.ErrInvalidTokenID for prefix containing \".\", got %+v\n", err)
+	}
+
+	// a UUID is 36 bytes, so this is one byte too long
+	deps.IDPrefix = strings.Repeat("a", tokens.MaxTokenIDLength-35)
+	deps.IDGenerator = nil
+	_, err = deps.FillTokenDefaults(tokens.RefreshToken{})
+	if !errors.Is(err, tokens.ErrInvalidTokenID) {
+		t.Errorf("Expected tokens.ErrInvalidTokenID for ID longer than %d bytes, got %+v\n", tokens.MaxTokenIDLength, err)
+	}
+	deps.IDPrefix = strings.Repeat("a", tokens.MaxTokenIDLength-36)
+	token, err = deps.FillTokenDefaults(tokens.RefreshToken{})
+	if err != nil {
+		t.Fatalf("Unexpected error filling token defaults with longest prefix: %+v\n", err)
+	}
+	if len(token.ID) != tokens.MaxTokenIDLength {
+		t.Errorf("Expected ID to be %d bytes, got %q", tokens.MaxTokenIDLength, token.ID)
+	}
+}
+
+func TestCreateTokenDottedID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	deps.IDGenerator = func() (string, error) { return "dotted.id", nil }
+
+	for pos, token := range []tokens.RefreshToken{
+		{ID: "preset.id"},
+		{},
+	} {
+		token.CreatedFrom = "test case"
+		token.ProfileID = "profile"
+		token.AccountID = "account"
+		token.ClientID = "client"
+		_, err := deps.CreateToken(ctx, token)
+		if !errors.Is(err, tokens.ErrInvalidTokenID) {
+			t.Errorf("Case %d: expected tokens.ErrInvalidTokenID, got %+v\n", pos, err)
+		}
+	}
+	_, err := deps.Storer.GetToken(ctx, "dotted.id")
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected dotted token not to be stored, got %+v\n", err)
+	}
+}
+
+func TestScopeDelimiter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	// spaces aren't allowed in scopes with the default delimiter
+	_, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		Scopes:      []string{"https://scopes.impractical.co/profiles/view me"},
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if !errors.Is(err, tokens.ErrInvalidScope) {
+		t.Errorf("Expected tokens.ErrInvalidScope, got %+v\n", err)
+	}
+
+	deps.ScopeDelimiter = ","
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		Scopes:      []string{"https://scopes.impractical.co/profiles/view me", "https://scopes.impractical.co/profiles/edit me"},
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token with custom delimiter: %+v\n", err)
+	}
+	_, err = deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		Scopes:      []string{"https://scopes.impractical.co/profiles/view,edit"},
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if !errors.Is(err, tokens.ErrInvalidScope) {
+		t.Errorf("Expected tokens.ErrInvalidScope with custom delimiter, got %+v\n", err)
+	}
+
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	result, err := deps.Introspect(ctx, jwtVal)
+	if err != nil {
+		t.Fatalf("Unexpected error introspecting token: %+v\n", err)
+	}
+	expected := "https://scopes.impractical.co/profiles/view me,https://scopes.impractical.co/profiles/edit me"
+	if result.Scope != expected {
+		t.Errorf("Expected scope %q, got %q", expected, result.Scope)
+	}
+	if diff := cmp.Diff(token.Scopes, strings.Split(result.Scope, deps.ScopeDelimiter)); diff != "" {
+		t.Errorf("Unexpected diff splitting scope (-wanted, +got): %s", diff)
+	}
+}
+
+func TestValidateGraceful(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	deps.GracePeriod = 5 * time.Minute
+	lifetime := 14 * 24 * time.Hour
+
+	type testCase struct {
+		expiredFor time.Duration
+		inGrace    bool
+		err        error
+	}
+	for name, test := range map[string]testCase{
+		"just-before-exp": {expiredFor: -1 * time.Minute},
+		"within-grace":    {expiredFor: time.Minute, inGrace: true},
+		"past-grace":      {expiredFor: 10 * time.Minute, err: tokens.ErrTokenExpired},
+	} {
+		token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedAt:   time.Now().Add(-1 * (lifetime + test.expiredFor)),
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error creating token: %+v\n", name, err)
+		}
+		jwtVal, err := deps.CreateJWT(ctx, token)
+		if err != nil {
+			t.Fatalf("%s: unexpected error creating JWT: %+v\n", name, err)
+		}
+		result, inGrace, err := deps.ValidateGraceful(ctx, jwtVal)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected error %v, got %+v\n", name, test.err, err)
+		}
+		if inGrace != test.inGrace {
+			t.Errorf("%s: expected inGrace to be %v, got %v", name, test.inGrace, inGrace)
+		}
+		if test.err == nil && result.ID != token.ID {
+			t.Errorf("%s: expected token %q, got %q", name, token.ID, result.ID)
+		}
+
+		// Validate never applies a grace period
+		_, err = deps.Validate(ctx, jwtVal)
+		if test.expiredFor > 0 && !errors.Is(err, tokens.ErrTokenExpired) {
+			t.Errorf("%s: expected tokens.ErrTokenExpired from Validate, got %+v\n", name, err)
+		}
+	}
+}
+
+func TestRotateUsedTokenRecordsReuseAttempts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	var reused []int
+	deps.OnTokenReuse = func(_ context.Context, token tokens.RefreshToken, attempts int) {
+		if token.ProfileID != "profile" {
+			t.Errorf("Expected reused token to be passed to hook, got %+v", token)
+		}
+		reused = append(reused, attempts)
+	}
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	_, err = deps.RotateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error rotating token: %+v\n", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = deps.Validate(ctx, jwtVal)
+		if !errors.Is(err, tokens.ErrTokenUsed) {
+			t.Errorf("Expected tokens.ErrTokenUsed validating, got %+v\n", err)
+		}
+	}
+	if len(reused) != 0 {
+		t.Errorf("Expected Validate not to call OnTokenReuse, got %v", reused)
+	}
+	attempts, err := deps.Storer.GetReuseAttempts(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving reuse attempts: %+v\n", err)
+	}
+	if attempts != 0 {
+		t.Errorf("Expected %d reuse attempts after validating, got %d", 0, attempts)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = deps.RotateToken(ctx, token)
+		if !errors.Is(err, tokens.ErrTokenUsed) {
+			t.Errorf("Expected tokens.ErrTokenUsed rotating, got %+v\n", err)
+		}
+	}
+	if diff := cmp.Diff([]int{1, 2}, reused); diff != "" {
+		t.Errorf("Unexpected diff in reuse attempts passed to hook (-wanted, +got): %s", diff)
+	}
+	attempts, err = deps.Storer.GetReuseAttempts(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving reuse attempts: %+v\n", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected %d reuse attempts after rotating, got %d", 2, attempts)
+	}
+}
+
+func TestIntrospectUsedTokenDoesNotRecordReuse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	var reused int
+	deps.OnTokenReuse = func(_ context.Context, _ tokens.RefreshToken, _ int) {
+		reused++
+	}
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	err = deps.Storer.UseToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error using token: %+v\n", err)
+	}
+
+	introspection, err := deps.Introspect(ctx, jwtVal)
+	if err != nil {
+		t.Fatalf("Unexpected error introspecting token: %+v\n", err)
+	}
+	if introspection.Active {
+		t.Errorf("Expected used token to be inactive, got %+v", introspection)
+	}
+	if reused != 0 {
+		t.Errorf("Expected OnTokenReuse not to be called, was called %d times", reused)
+	}
+	attempts, err := deps.Storer.GetReuseAttempts(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving reuse attempts: %+v\n", err)
+	}
+	if attempts != 0 {
+		t.Errorf("Expected %d reuse attempts, got %d", 0, attempts)
+	}
+}
+
+func TestRotateTokenFamily(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	first, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	if first.FamilyID != first.ID {
+		t.Errorf("Expected first token to start a family with its own ID %q, got %q", first.ID, first.FamilyID)
+	}
+
+	chain := []tokens.RefreshToken{first}
+	for i := 0; i < 2; i++ {
+		next, err := deps.RotateToken(ctx, chain[len(chain)-1])
+		if err != nil {
+			t.Fatalf("Unexpected error rotating token: %+v\n", err)
+		}
+		if next.ID == first.ID || next.FamilyID != first.ID {
+			t.Errorf("Expected a new token in family %q, got ID %q in family %q", first.ID, next.ID, next.FamilyID)
+		}
+		if diff := cmp.Diff(first.Scopes, next.Scopes); diff != "" {
+			t.Errorf("Unexpected diff in scopes (-wanted, +got): %s", diff)
+		}
+		chain = append(chain, next)
+	}
+
+	// rotated tokens are used, and can't be rotated again
+	_, err = deps.RotateToken(ctx, first)
+	if !errors.Is(err, tokens.ErrTokenUsed) {
+		t.Errorf("Expected tokens.ErrTokenUsed rotating used token, got %+v\n", err)
+	}
+
+	// revoking the family by any member's FamilyID revokes the whole chain
+	revoked, err := deps.Storer.RevokeTokenFamily(ctx, chain[1].FamilyID)
+	if err != nil {
+		t.Fatalf("Unexpected error revoking token family: %+v\n", err)
+	}
+	if revoked != len(chain) {
+		t.Errorf("Expected %d tokens to be revoked, got %d", len(chain), revoked)
+	}
+	for pos, token := range chain {
+		result, err := deps.Storer.GetToken(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving token %d: %+v\n", pos, err)
+		}
+		if !result.Revoked {
+			t.Errorf("Expected token %d to be revoked", pos)
+		}
+	}
+}
+
+// failingCreateStorer is a tokens.Storer that can't create tokens, and
+// doesn't support transactions.
+type failingCreateStorer struct {
+	tokens.Storer
+}
+
+func (failingCreateStorer) CreateToken(_ context.Context, _ tokens.RefreshToken) error {
+	return errCreateFailed
+}
+
+var errCreateFailed = errors.New("create failed")
+
+func TestRotateTokenFailedCreate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	type testCase struct {
+		storer func(tokens.Storer) tokens.Storer
+		deps   func(*tokens.Dependencies, tokens.RefreshToken)
+		err    error
+	}
+	tests := map[string]testCase{
+		"invalid-next-token": {
+			storer: func(s tokens.Storer) tokens.Storer { return deadline.NewStorer(s) },
+			deps:   func(d *tokens.Dependencies, _ tokens.RefreshToken) { d.MaxScopes = 1 },
+			err:    tokens.ErrTooManyScopes,
+		},
+		"transaction-rolled-back": {
+			storer: func(s tokens.Storer) tokens.Storer { return deadline.NewStorer(s) },
+			deps: func(d *tokens.Dependencies, existing tokens.RefreshToken) {
+				d.IDGenerator = func() (string, error) { return existing.ID, nil }
+			},
+			err: tokens.ErrTokenAlreadyExists,
+		},
+		"no-transaction": {
+			storer: func(s tokens.Storer) tokens.Storer { return deadline.NewStorer(failingCreateStorer{s}) },
+			deps:   func(_ *tokens.Dependencies, _ tokens.RefreshToken) {},
+			err:    errCreateFailed,
+		},
+	}
+
+	for name, test := range tests {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			deps := newDependencies(t)
+			token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+				CreatedFrom: "test case",
+				Scopes:      []string{"https://scopes.impractical.co/profiles/view:me", "https://scopes.impractical.co/profiles/edit:me"},
+				ProfileID:   "profile",
+				AccountID:   "account",
+				ClientID:    "client",
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error creating token: %+v\n", err)
+			}
+			inner := deps.Storer
+			deps.Storer = test.storer(inner)
+			test.deps(&deps, token)
+
+			_, err = deps.RotateToken(ctx, token)
+			if !errors.Is(err, test.err) {
+				t.Errorf("Expected %v rotating token, got %+v\n", test.err, err)
+			}
+			result, err := inner.GetToken(ctx, token.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+			}
+			if result.Used {
+				t.Error("Expected token to still be usable after failed rotation")
+			}
+		})
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	err := deps.CheckKeys()
+	if err != nil {
+		t.Errorf("Unexpected error checking 2048-bit key: %+v\n", err)
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024) //nolint:gomnd // deliberately undersized
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %+v\n", err)
+	}
+	deps.JWTPrivateKey = small
+	deps.JWTPublicKey = &small.PublicKey
+	err = deps.CheckKeys()
+	if !errors.Is(err, tokens.ErrRSAKeyTooSmall) {
+		t.Errorf("Expected tokens.ErrRSAKeyTooSmall, got %+v\n", err)
+	}
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	_, err = deps.CreateJWT(ctx, token)
+	if !errors.Is(err, tokens.ErrRSAKeyTooSmall) {
+		t.Errorf("Expected tokens.ErrRSAKeyTooSmall signing with undersized key, got %+v\n", err)
+	}
+
+	deps.MinRSAKeyBits = 1024
+	err = deps.CheckKeys()
+	if err != nil {
+		t.Errorf("Unexpected error checking 1024-bit key with lowered minimum: %+v\n", err)
+	}
+
+	// a JWT signed by the undersized key, now that it's allowed, shouldn't
+	// be verified by Dependencies that use the default minimum, wherever
+	// they get the key from
+	smallJWT, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT with lowered minimum: %+v\n", err)
+	}
+	smallKeys, err := tokens.NewPublicKeys(&small.PublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error creating key set: %+v\n", err)
+	}
+	verifiers := map[string]tokens.Dependencies{}
+	verifier := newDependencies(t)
+	verifier.Storer = deps.Storer
+	verifier.JWTPublicKey = &small.PublicKey
+	verifiers["JWTPublicKey"] = verifier
+	verifier.JWTPublicKey = nil
+	verifier.KeySet = smallKeys
+	verifiers["KeySet"] = verifier
+	for name, verifier := range verifiers {
+		_, err = verifier.Validate(ctx, smallJWT)
+		if !errors.Is(err, tokens.ErrInvalidToken) {
+			t.Errorf("Expected tokens.ErrInvalidToken validating with undersized %s, got %+v\n", name, err)
+		}
+	}
+	deps.KeySet = smallKeys
+	_, err = deps.Validate(ctx, smallJWT)
+	if err != nil {
+		t.Errorf("Unexpected error validating with undersized key and lowered minimum: %+v\n", err)
+	}
+
+	// a Signer is checked too
+	signer, err := tokens.NewRSASigner(newDependencies(t).JWTPrivateKey)
+	if err != nil {
+		t.Fatalf("Unexpected error creating RSA signer: %+v\n", err)
+	}
+	deps = newDependencies(t)
+	deps.Signer = signer
+	deps.MinRSAKeyBits = 3072
+	deps.JWTPrivateKey, deps.JWTPublicKey = nil, nil
+	err = deps.CheckKeys()
+	if !errors.Is(err, tokens.ErrRSAKeyTooSmall) {
+		t.Errorf("Expected tokens.ErrRSAKeyTooSmall checking 2048-bit signer with raised minimum, got %+v\n", err)
+	}
+	_, err = deps.CreateJWT(ctx, token)
+	if !errors.Is(err, tokens.ErrRSAKeyTooSmall) {
+		t.Errorf("Expected tokens.ErrRSAKeyTooSmall signing with 2048-bit signer with raised minimum, got %+v\n", err)
+	}
+}
+
+func TestCreateTokenRequireUUIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	validUUID := "6b9b8c5e-2d4a-4c1f-9a3e-7f0d2b1c5a48"
+	token := tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "not-a-uuid",
+		AccountID:   validUUID,
+		ClientID:    validUUID,
+	}
+
+	_, err := deps.CreateToken(ctx, token)
+	if err != nil {
+		t.Errorf("Unexpected error creating token with opaque profile ID: %+v\n", err)
+	}
+
+	deps.RequireUUIDs = true
+	_, err = deps.CreateToken(ctx, token)
+	if !errors.Is(err, tokens.ErrInvalidUUID) {
+		t.Errorf("Expected tokens.ErrInvalidUUID, got %+v\n", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "ProfileID") {
+		t.Errorf("Expected error to name the ProfileID field, got %q", err.Error())
+	}
+
+	token.ProfileID = validUUID
+	_, err = deps.CreateToken(ctx, token)
+	if err != nil {
+		t.Errorf("Unexpected error creating token with UUIDs: %+v\n", err)
+	}
+}
+
+func TestCreateTokenDefaultScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	deps.DefaultScopes = []string{"profile:read"}
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	if diff := cmp.Diff([]string{"profile:read"}, token.Scopes); diff != "" {
+		t.Errorf("Unexpected diff in default scopes (-wanted, +got): %s", diff)
+	}
+
+	// scopes that are already set are never overridden
+	token, err = deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+		Scopes:      []string{"profile:write"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	if diff := cmp.Diff([]string{"profile:write"}, token.Scopes); diff != "" {
+		t.Errorf("Unexpected diff in provided scopes (-wanted, +got): %s", diff)
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	create := func(t *testing.T) tokens.RefreshToken {
+		t.Helper()
+		token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		return token
+	}
+
+	valid := create(t)
+	result, err := deps.ValidateClaims(ctx, &jwt.RegisteredClaims{ID: valid.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error validating claims: %+v\n", err)
+	}
+	if diff := cmp.Diff(valid, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	revoked := create(t)
+	revokedVal := true
+	_, err = deps.Storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: revoked.ID, Revoked: &revokedVal})
+	if err != nil {
+		t.Fatalf("Unexpected error revoking token: %+v\n", err)
+	}
+	_, err = deps.ValidateClaims(ctx, &jwt.RegisteredClaims{ID: revoked.ID})
+	if !errors.Is(err, tokens.ErrTokenRevoked) {
+		t.Errorf("Expected tokens.ErrTokenRevoked, got %+v\n", err)
+	}
+
+	used := create(t)
+	err = deps.Storer.UseToken(ctx, used.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error using token: %+v\n", err)
+	}
+	_, err = deps.ValidateClaims(ctx, &jwt.RegisteredClaims{ID: used.ID})
+	if !errors.Is(err, tokens.ErrTokenUsed) {
+		t.Errorf("Expected tokens.ErrTokenUsed, got %+v\n", err)
+	}
+
+	_, err = deps.ValidateClaims(ctx, &jwt.RegisteredClaims{ID: "not-a-token"})
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken for missing token, got %+v\n", err)
+	}
+
+	_, err = deps.ValidateClaims(ctx, nil)
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken for nil claims, got %+v\n", err)
+	}
+}
+
+func TestValidateMaxJWTLength(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+
+	_, err = deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Errorf("Unexpected error validating token with the default maximum length: %+v\n", err)
+	}
+
+	deps.MaxJWTLength = len(jwtVal)
+	_, err = deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Errorf("Unexpected error validating token at the maximum length: %+v\n", err)
+	}
+
+	deps.MaxJWTLength = len(jwtVal) - 1
+	_, err = deps.Validate(ctx, jwtVal)
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken for token over the maximum length, got %+v\n", err)
+	}
+
+	deps.MaxJWTLength = 0
+	_, err = deps.Validate(ctx, strings.Repeat("a", tokens.DefaultMaxJWTLength+1))
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken for token over the default maximum length, got %+v\n", err)
+	}
+}
+
+func TestReissueJWT(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+
+	jwtVal, err := deps.ReissueJWT(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error reissuing JWT: %+v\n", err)
+	}
+	result, err := deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Fatalf("Unexpected error validating reissued JWT: %+v\n", err)
+	}
+	if diff := cmp.Diff(token, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+
+	revoked := true
+	_, err = deps.Storer.UpdateTokens(ctx, tokens.RefreshTokenChange{ID: token.ID, Revoked: &revoked})
+	if err != nil {
+		t.Fatalf("Unexpected error revoking token: %+v\n", err)
+	}
+	_, err = deps.ReissueJWT(ctx, token.ID)
+	if !errors.Is(err, tokens.ErrTokenRevoked) {
+		t.Errorf("Expected tokens.ErrTokenRevoked, got %+v\n", err)
+	}
+
+	_, err = deps.ReissueJWT(ctx, "not-a-token")
+	if !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected tokens.ErrTokenNotFound, got %+v\n", err)
+	}
+}
+
+func TestNormalizeScope(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	// scopes are stored and matched verbatim by default
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+		Scopes:      []string{"Profiles:Read"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	if diff := cmp.Diff([]string{"Profiles:Read"}, token.Scopes); diff != "" {
+		t.Errorf("Unexpected diff in verbatim scopes (-wanted, +got): %s", diff)
+	}
+	if deps.HasScope(token, "profiles:read") {
+		t.Error("Expected differently-cased scope not to match without normalization")
+	}
+
+	deps.NormalizeScope = func(scope string) string {
+		return strings.ToLower(strings.TrimSpace(scope))
+	}
+	if !deps.HasScope(token, " PROFILES:READ") {
+		t.Error("Expected differently-cased scope to match with normalization")
+	}
+
+	token, err = deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+		Scopes:      []string{"Profiles:Read ", "profiles:WRITE"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	if diff := cmp.Diff([]string{"profiles:read", "profiles:write"}, token.Scopes); diff != "" {
+		t.Errorf("Unexpected diff in normalized scopes (-wanted, +got): %s", diff)
+	}
+	stored, err := deps.Storer.GetToken(ctx, token.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving token: %+v\n", err)
+	}
+	if diff := cmp.Diff(token.Scopes, stored.Scopes); diff != "" {
+		t.Errorf("Unexpected diff in stored scopes (-wanted, +got): %s", diff)
+	}
+	for _, scope := range []string{"profiles:read", "Profiles:Read", "PROFILES:WRITE"} {
+		if !deps.HasScope(stored, scope) {
+			t.Errorf("Expected scope %q to match", scope)
+		}
+	}
+	if deps.HasScope(stored, "profiles:delete") {
+		t.Error("Expected missing scope not to match")
+	}
+}
+
+func TestCreateTokenDuplicateScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	newToken := func() tokens.RefreshToken {
+		return tokens.RefreshToken{
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+			Scopes:      []string{"read", "write", "read"},
+		}
+	}
+
+	type testCase struct {
+		policy   tokens.DuplicateScopePolicy
+		expected []string
+		err      error
+	}
+	for name, test := range map[string]testCase{
+		"allowed":  {policy: tokens.DuplicateScopesAllowed, expected: []string{"read", "write", "read"}},
+		"removed":  {policy: tokens.DuplicateScopesRemoved, expected: []string{"read", "write"}},
+		"rejected": {policy: tokens.DuplicateScopesRejected, err: tokens.ErrInvalidScope},
+	} {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			deps := newDependencies(t)
+			deps.DuplicateScopes = test.policy
+			token, err := deps.CreateToken(ctx, newToken())
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Expected error %v, got %+v\n", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.expected, token.Scopes); diff != "" {
+				t.Errorf("Unexpected diff in scopes (-wanted, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyChangeIsPure(t *testing.T) {
+	t.Parallel()
+
+	original := tokens.RefreshToken{
+		ID:        "token",
+		CreatedAt: time.Now().Round(time.Millisecond),
+		Scopes:    []string{"https://test.lockbox.dev/basic/scope"},
+	}
+	token := original
+	token.Scopes = append([]string(nil), original.Scopes...)
+
+	revoked, used := true, true
+	scopes := []string{"https://test.lockbox.dev/updated/scope"}
+	createdAt := original.CreatedAt.Add(time.Hour)
+	change := tokens.RefreshTokenChange{
+		Revoked:   &revoked,
+		Used:      &used,
+		Scopes:    &scopes,
+		CreatedAt: &createdAt,
+	}
+	result := tokens.ApplyChange(token, change)
+	scopes[0] = "https://test.lockbox.dev/mutated/scope"
+
+	expected := tokens.RefreshToken{
+		ID:        "token",
+		CreatedAt: createdAt,
+		Scopes:    []string{"https://test.lockbox.dev/updated/scope"},
+		Revoked:   true,
+		Used:      true,
+	}
+	if diff := cmp.Diff(expected, result); diff != "" {
+		t.Errorf("Unexpected diff (-wanted, +got): %s", diff)
+	}
+	if diff := cmp.Diff(original, token); diff != "" {
+		t.Errorf("Unexpected diff in original token (-wanted, +got): %s", diff)
+	}
+
+	result = tokens.ApplyChange(token, tokens.RefreshTokenChange{Scopes: &[]string{}})
+	if result.Scopes != nil {
+		t.Errorf("Expected clearing scopes to leave nil scopes, got %#v", result.Scopes)
+	}
+}
+
+func TestValidateSigningAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	_, err = deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Errorf("Unexpected error validating token signed with a permitted algorithm: %+v\n", err)
+	}
+
+	for name, signed := range map[string]string{
+		"unsigned token":                   resignWithAlgorithm(t, jwtVal, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+		"token signed with the public key": resignWithAlgorithm(t, jwtVal, jwt.SigningMethodHS256, publicKeyPEM(t, deps.JWTPublicKey)),
+	} {
+		_, err = deps.Validate(ctx, signed)
+		if !errors.Is(err, tokens.ErrUnexpectedSigningMethod) {
+			t.Errorf("Expected tokens.ErrUnexpectedSigningMethod for %s, got %+v\n", name, err)
+		}
+		if !errors.Is(err, tokens.ErrInvalidToken) {
+			t.Errorf("Expected tokens.ErrInvalidToken for %s, got %+v\n", name, err)
+		}
+	}
+
+	deps.SigningAlgorithms = []string{"RS512"}
+	_, err = deps.Validate(ctx, jwtVal)
+	if !errors.Is(err, tokens.ErrUnexpectedSigningMethod) {
+		t.Errorf("Expected tokens.ErrUnexpectedSigningMethod for token signed with an algorithm that isn't permitted, got %+v\n", err)
+	}
+}
+
+func TestValidateAudiences(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	signer, err := tokens.NewRSASigner(deps.JWTPrivateKey)
+	if err != nil {
+		t.Fatalf("Unexpected error creating signer: %+v\n", err)
+	}
+	jwtTok := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.RegisteredClaims{
+		Audience:  jwt.ClaimStrings{"client", "https://resource.test.lockbox.dev"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		ID:        token.ID,
+		IssuedAt:  jwt.NewNumericDate(token.CreatedAt),
+		Issuer:    deps.ServiceID,
+		Subject:   token.ProfileID,
+	})
+	jwtTok.Header["kid"] = signer.KeyID()
+	jwtVal, err := jwtTok.SignedString(deps.JWTPrivateKey)
+	if err != nil {
+		t.Fatalf("Unexpected error signing JWT: %+v\n", err)
+	}
+
+	tests := map[string]struct {
+		audiences []string
+		err       error
+	}{
+		"unset":        {},
+		"client":       {audiences: []string{"client"}},
+		"resource":     {audiences: []string{"https://resource.test.lockbox.dev", "https://other.test.lockbox.dev"}},
+		"both":         {audiences: []string{"client", "https://resource.test.lockbox.dev"}},
+		"disjoint":     {audiences: []string{"other-client", "https://other.test.lockbox.dev"}, err: tokens.ErrInvalidToken},
+		"single-other": {audiences: []string{"other-client"}, err: tokens.ErrInvalidToken},
+	}
+	for name, test := range tests {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			deps := deps
+			deps.Audiences = test.audiences
+			_, err := deps.Validate(ctx, jwtVal)
+			if !errors.Is(err, test.err) {
+				t.Errorf("Expected error %v, got %+v\n", test.err, err)
+			}
+		})
+	}
+}
+
+func TestTokenType(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+
+	token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+		CreatedFrom: "test case",
+		ProfileID:   "profile",
+		AccountID:   "account",
+		ClientID:    "client",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating token: %+v\n", err)
+	}
+	jwtVal, err := deps.CreateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+	}
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(jwtVal, claims)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing JWT: %+v\n", err)
+	}
+	if claims["token_use"] != tokens.DefaultTokenType {
+		t.Errorf("Expected token_use claim to be %q, got %v", tokens.DefaultTokenType, claims["token_use"])
+	}
+
+	deps.RequireTokenType = true
+	_, err = deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Errorf("Unexpected error validating token with the right type: %+v\n", err)
+	}
+
+	deps.TokenType = "access"
+	_, err = deps.Validate(ctx, jwtVal)
+	if !errors.Is(err, tokens.ErrInvalidToken) {
+		t.Errorf("Expected tokens.ErrInvalidToken validating token with the wrong type, got %+v\n", err)
+	}
+
+	deps.RequireTokenType = false
+	_, err = deps.Validate(ctx, jwtVal)
+	if err != nil {
+		t.Errorf("Unexpected error validating token with the wrong type when it isn't enforced: %+v\n", err)
+	}
+}
+
+func TestExpiryJitter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	createdAt := time.Now().Round(time.Second)
+
+	expiry := func(deps tokens.Dependencies, token tokens.RefreshToken) time.Time {
+		t.Helper()
+		jwtVal, err := deps.CreateJWT(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+		}
+		claims := &jwt.RegisteredClaims{}
+		_, _, err = jwt.NewParser().ParseUnverified(jwtVal, claims)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing JWT: %+v\n", err)
+		}
+		return claims.ExpiresAt.Time
+	}
+
+	jittered := deps
+	jittered.ExpiryJitter = time.Hour
+	seen := map[time.Time]struct{}{}
+	for i := 0; i < 20; i++ {
+		token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedAt:   createdAt,
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    "client",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		latest := expiry(deps, token)
+		exp := expiry(jittered, token)
+		if exp.After(latest) || exp.Before(latest.Add(-1*jittered.ExpiryJitter)) {
+			t.Errorf("Expected expiry within %s before %s, got %s", jittered.ExpiryJitter, latest, exp)
+		}
+		if again := expiry(jittered, token); !again.Equal(exp) {
+			t.Errorf("Expected reissued JWT to expire at %s, got %s", exp, again)
+		}
+		seen[exp] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Errorf("Expected jittered expiries to vary across tokens, got %v", seen)
+	}
+}
+
+func TestValidationHook(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	deps := newDependencies(t)
+	errMaintenance := errors.New("client in maintenance")
+	deps.ValidationHook = func(_ context.Context, token tokens.RefreshToken) error {
+		if token.ClientID == "maintenance" {
+			return errMaintenance
+		}
+		return nil
+	}
+
+	for _, client := range []string{"client", "maintenance"} {
+		token, err := deps.CreateToken(ctx, tokens.RefreshToken{
+			CreatedFrom: "test case",
+			ProfileID:   "profile",
+			AccountID:   "account",
+			ClientID:    client,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error creating token: %+v\n", err)
+		}
+		jwtVal, err := deps.CreateJWT(ctx, token)
+		if err != nil {
+			t.Fatalf("Unexpected error creating JWT: %+v\n", err)
+		}
+		_, err = deps.Validate(ctx, jwtVal)
+		if client == "maintenance" && (!errors.Is(err, errMaintenance) || !errors.Is(err, tokens.ErrInvalidToken)) {
+			t.Errorf("Expected hook error matching tokens.ErrInvalidToken validating token for %q, got %+v\n", client, err)
+		} else if client != "maintenance" && err != nil {
+			t.Errorf("Unexpected error validating token for %q: %+v\n", client, err)
+		}
+
+		result, err := deps.Introspect(ctx, jwtVal)
+		if err != nil {
+			t.Errorf("Unexpected error introspecting token for %q: %+v\n", client, err)
+		}
+		if result.Active != (client != "maintenance") {
+			t.Errorf("Expected token for %q to be active=%v, got %+v\n", client, client != "maintenance", result)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tokenstest
+
+import (
+	"testing"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"lockbox.dev/tokens"
+)
+
+// Option overrides the default value of one or more properties of the
+// tokens.RefreshToken built by NewToken.
+type Option func(*tokens.RefreshToken)
+
+// WithID sets the ID of the tokens.RefreshToken.
+func WithID(id string) Option {
+	return func(token *tokens.RefreshToken) {
+		token.ID = id
+	}
+}
+
+// WithCreatedAt sets the CreatedAt of the tokens.RefreshToken.
+func WithCreatedAt(createdAt time.Time) Option {
+	return func(token *tokens.RefreshToken) {
+		token.CreatedAt = createdAt
+	}
+}
+
+// WithScopes sets the Scopes of the tokens.RefreshToken.
+func WithScopes(scopes ...string) Option {
+	return func(token *tokens.RefreshToken) {
+		token.Scopes = scopes
+	}
+}
+
+// WithAccountID sets the AccountID of the tokens.RefreshToken.
+func WithAccountID(accountID string) Option {
+	return func(token *tokens.RefreshToken) {
+		token.AccountID = accountID
+	}
+}
+
+// WithProfileID sets the ProfileID of the tokens.RefreshToken.
+func WithProfileID(profileID string) Option {
+	return func(token *tokens.RefreshToken) {
+		token.ProfileID = profileID
+	}
+}
+
+// WithClientID sets the ClientID of the tokens.RefreshToken.
+func WithClientID(clientID string) Option {
+	return func(token *tokens.RefreshToken) {
+		token.ClientID = clientID
+	}
+}
+
+// WithFamilyID sets the FamilyID of the tokens.RefreshToken.
+func WithFamilyID(familyID string) Option {
+	return func(token *tokens.RefreshToken) {
+		token.FamilyID = familyID
+	}
+}
+
+// WithRevoked sets whether the tokens.RefreshToken is revoked.
+func WithRevoked(revoked bool) Option {
+	return func(token *tokens.RefreshToken) {
+		token.Revoked = revoked
+	}
+}
+
+// WithUsed sets whether the tokens.RefreshToken is used.
+func WithUsed(used bool) Option {
+	return func(token *tokens.RefreshToken) {
+		token.Used = used
+	}
+}
+
+// NewToken returns a tokens.RefreshToken for use in tests, with `opts`
+// applied to it. Properties that aren't set by `opts` have defaults: the
+// ID, AccountID, ProfileID, and ClientID are random UUIDs, the FamilyID
+// is the ID, the CreatedAt is an hour ago, there is a single scope, and
+// the tokens.RefreshToken is neither revoked nor used.
+//
+// The CreatedAt is rounded to the millisecond, as that's all PostgreSQL
+// stores.
+func NewToken(t testing.TB, opts ...Option) tokens.RefreshToken {
+	t.Helper()
+	token := tokens.RefreshToken{
+		ID:          uuidOrFail(t),
+		CreatedAt:   time.Now().Add(-1 * time.Hour).Round(time.Millisecond),
+		CreatedFrom: "test case for " + t.Name(),
+		Scopes:      []string{"https://scopes.impractical.co/profiles/view:me"},
+		AccountID:   uuidOrFail(t),
+		ProfileID:   uuidOrFail(t),
+		ClientID:    uuidOrFail(t),
+	}
+	for _, opt := range opts {
+		opt(&token)
+	}
+	if token.FamilyID == "" {
+		token.FamilyID = token.ID
+	}
+	return token
+}
+
+func uuidOrFail(t testing.TB) string {
+	t.Helper()
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatalf("Unexpected error generating ID: %s", err.Error())
+	}
+	return id
+}